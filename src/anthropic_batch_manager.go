@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultAnthropicBaseURL Anthropic 接口默认地址
+const defaultAnthropicBaseURL = "https://api.anthropic.com/v1"
+
+// anthropicVersion Anthropic 接口版本
+const anthropicVersion = "2023-06-01"
+
+// Anthropic Message Batches 的 processing_status
+const (
+	anthropicStatusInProgress = "in_progress"
+	anthropicStatusCanceling  = "canceling"
+	anthropicStatusEnded      = "ended"
+)
+
+// 结果文件id后缀：Anthropic 没有独立的结果文件，用 batch id + 后缀区分成功和失败的结果
+const (
+	anthropicOutputSuffix = "#succeeded"
+	anthropicErrorSuffix  = "#failed"
+)
+
+// localFilePrefix Anthropic 的请求在创建batch时内联提交，上传文件id只记录本地路径
+const localFilePrefix = "local:"
+
+// AnthropicBatchManager Anthropic Message Batches 接口
+type AnthropicBatchManager struct {
+	baseURL string
+	apiKey  string
+}
+
+var _ BatchProvider = (*AnthropicBatchManager)(nil)
+
+// NewAnthropicBatchManager 创建 Anthropic 批处理管理器，baseURL 形如 https://api.anthropic.com/v1
+func NewAnthropicBatchManager(baseURL string) *AnthropicBatchManager {
+	return &AnthropicBatchManager{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  ModelConf.Password,
+	}
+}
+
+// do 发送请求并解析JSON响应
+func (am *AnthropicBatchManager) do(method string, path string, body io.Reader) (map[string]interface{}, error) {
+	req, err := http.NewRequest(method, am.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", am.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先检查状态码：网关返回的 5xx/429 可能是 HTML 或纯文本，需保留状态码与原始内容供重试与错误分类
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("请求 %s 失败(%d): %s", path, resp.StatusCode, string(respBody))
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("解析 %s 响应失败: %v, 响应内容: %s", path, err, string(respBody))
+	}
+	return result, nil
+}
+
+// UploadFile Anthropic 不需要上传文件，请求在 CreateBatchTask 中内联提交
+func (am *AnthropicBatchManager) UploadFile(filePath string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	return localFilePrefix + filePath, nil
+}
+
+// CreateBatchTask 读取文件块中的 {"custom_id","params"} 行并创建 Message Batch
+func (am *AnthropicBatchManager) CreateBatchTask(inputFileID string) (string, error) {
+	filePath := strings.TrimPrefix(inputFileID, localFilePrefix)
+	body := &bytes.Buffer{}
+	body.WriteString(`{"requests":[`)
+	count := 0
+	err := forEachLine(filePath, func(line string) error {
+		if count > 0 {
+			body.WriteByte(',')
+		}
+		body.WriteString(line)
+		count++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	body.WriteString(`]}`)
+
+	logInfo("创建 Anthropic batch 任务请求：%s (请求数: %d)", filePath, count)
+
+	result, err := am.do("POST", "/messages/batches", body)
+	if err != nil {
+		return "", err
+	}
+
+	logInfo("创建 Anthropic batch 任务结果：%v", result)
+
+	id, ok := result["id"].(string)
+	if !ok {
+		return "", fmt.Errorf("响应中缺少id字段")
+	}
+	return id, nil
+}
+
+// GetResult 查询 Message Batch，并将 processing_status/request_counts 映射为 BatchTaskInfo
+func (am *AnthropicBatchManager) GetResult(batchID string) (*BatchTaskInfo, error) {
+	resp, err := am.do("GET", "/messages/batches/"+batchID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	logInfo("查询 Anthropic batch 任务结果：%v", resp)
+
+	processingStatus, ok := resp["processing_status"].(string)
+	if !ok {
+		return nil, fmt.Errorf("响应中缺少processing_status字段")
+	}
+
+	requestCounts, ok := resp["request_counts"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("响应中缺少request_counts字段")
+	}
+	processing, _ := requestCounts["processing"].(float64)
+	succeeded, _ := requestCounts["succeeded"].(float64)
+	errored, _ := requestCounts["errored"].(float64)
+	canceled, _ := requestCounts["canceled"].(float64)
+	expired, _ := requestCounts["expired"].(float64)
+
+	var status BatchStatus
+	switch processingStatus {
+	case anthropicStatusInProgress, anthropicStatusCanceling:
+		status = BatchStatusInProgress
+	case anthropicStatusEnded:
+		status = BatchStatusCompleted
+		if cancelAt, _ := resp["cancel_initiated_at"].(string); cancelAt != "" {
+			status = BatchStatusCanceled
+		} else if expired > 0 && succeeded == 0 && errored == 0 {
+			status = BatchStatusExpired
+		}
+	default:
+		return nil, nil
+	}
+
+	failed := errored + canceled + expired
+	batchTaskInfo := &BatchTaskInfo{
+		BatchID:        batchID,
+		Status:         status,
+		TotalCount:     int(processing + succeeded + failed),
+		CompletedCount: int(succeeded),
+		FailedCount:    int(failed),
+	}
+
+	if status != BatchStatusInProgress {
+		batchTaskInfo.OutputFileID = batchID + anthropicOutputSuffix
+		if failed > 0 {
+			errorFileID := batchID + anthropicErrorSuffix
+			batchTaskInfo.ErrorFileID = &errorFileID
+		}
+	}
+
+	logInfo("查询 Anthropic batch 任务结果：%+v", batchTaskInfo)
+
+	return batchTaskInfo, nil
+}
+
+// DownloadFile 下载 batch 结果，按文件id后缀筛选成功或失败的记录，并转换为 OpenAI batch 结果格式
+// 成功与失败的记录在同一个 results 流中：原始结果只下载一次（支持断点续传），保存在任务结果目录的 <batch_id>.raw.jsonl，
+// 两种结果都转换完（或其中没有失败记录）后再删除；转换完成后原子重命名为 destPath
+func (am *AnthropicBatchManager) DownloadFile(fileID string, destPath string) error {
+	wantSucceeded := strings.HasSuffix(fileID, anthropicOutputSuffix)
+	batchID := strings.TrimSuffix(strings.TrimSuffix(fileID, anthropicOutputSuffix), anthropicErrorSuffix)
+
+	// destPath 为 <任务结果目录>/output|error/<文件名>，原始结果放在两者共同的上级目录
+	rawPath := filepath.Join(filepath.Dir(filepath.Dir(destPath)), batchID+".raw.jsonl")
+	if _, err := os.Stat(rawPath); err != nil {
+		err := downloadToFile(func() (*http.Request, error) {
+			req, err := http.NewRequest("GET", am.baseURL+"/messages/batches/"+batchID+"/results", nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("x-api-key", am.apiKey)
+			req.Header.Set("anthropic-version", anthropicVersion)
+			return req, nil
+		}, rawPath)
+		if err != nil {
+			return fmt.Errorf("获取batch结果失败: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := destPath + ".tmp"
+	writer, err := createLineWriter(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	// 另一种结果的记录数，为 0 时不会再有针对该 batch 的下载
+	otherCount := 0
+	err = forEachLine(rawPath, func(line string) error {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			logInfo("警告: 解析 Anthropic 结果失败: %v", err)
+			return nil
+		}
+
+		converted := convertAnthropicResult(batchID, record)
+		if converted == nil {
+			return nil
+		}
+		if (converted["error"] == nil) != wantSucceeded {
+			otherCount++
+			return nil
+		}
+
+		data, err := json.Marshal(converted)
+		if err != nil {
+			return nil
+		}
+		return writer.WriteLine(string(data))
+	})
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return err
+	}
+	// 先转换成功结果、再转换失败结果（见 ChunkManager），成功结果转换完且存在失败记录时保留原始结果
+	if !wantSucceeded || otherCount == 0 {
+		os.Remove(rawPath)
+	}
+	return nil
+}
+
+// convertAnthropicResult 将一条 Anthropic 结果转换为 OpenAI batch 结果行
+// 成功：response.body 为 chat.completion 结构；失败：error 字段非空且 response.body.error 带错误类型
+func convertAnthropicResult(batchID string, record map[string]interface{}) map[string]interface{} {
+	customID, _ := record["custom_id"].(string)
+	result, ok := record["result"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	resultType, _ := result["type"].(string)
+
+	line := map[string]interface{}{
+		"id":        batchID,
+		"custom_id": customID,
+	}
+
+	if resultType == "succeeded" {
+		message, _ := result["message"].(map[string]interface{})
+		line["response"] = map[string]interface{}{
+			"status_code": http.StatusOK,
+			"body":        anthropicMessageToCompletion(message),
+		}
+		line["error"] = nil
+		return line
+	}
+
+	statusCode := http.StatusBadRequest
+	errType := "batch_" + resultType
+	errMessage := "请求未完成: " + resultType
+	if resultType == "errored" {
+		// result.error 为 {"type":"error","error":{"type":"...","message":"..."}}
+		if outer, ok := result["error"].(map[string]interface{}); ok {
+			inner, ok := outer["error"].(map[string]interface{})
+			if !ok {
+				inner = outer
+			}
+			if t, ok := inner["type"].(string); ok {
+				errType = t
+			}
+			if m, ok := inner["message"].(string); ok {
+				errMessage = m
+			}
+		}
+		statusCode = anthropicErrorStatus(errType)
+	}
+
+	errBody := map[string]interface{}{
+		"type":    errType,
+		"code":    errType,
+		"message": errMessage,
+	}
+	line["response"] = map[string]interface{}{
+		"status_code": statusCode,
+		"body":        map[string]interface{}{"error": errBody},
+	}
+	line["error"] = errBody
+	return line
+}
+
+// anthropicErrorStatus Anthropic 错误类型对应的 HTTP 状态码
+func anthropicErrorStatus(errType string) int {
+	switch errType {
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "overloaded_error":
+		return 529
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// anthropicMessageToCompletion 将 Anthropic message 转换为 chat.completion 结构
+func anthropicMessageToCompletion(message map[string]interface{}) map[string]interface{} {
+	var text, thinking strings.Builder
+	blocks, _ := message["content"].([]interface{})
+	for _, block := range blocks {
+		b, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch b["type"] {
+		case "text":
+			s, _ := b["text"].(string)
+			text.WriteString(s)
+		case "thinking":
+			s, _ := b["thinking"].(string)
+			thinking.WriteString(s)
+		}
+	}
+
+	assistant := map[string]interface{}{
+		"role":    "assistant",
+		"content": text.String(),
+	}
+	if thinking.Len() > 0 {
+		assistant["reasoning_content"] = thinking.String()
+	}
+
+	finishReason, _ := message["stop_reason"].(string)
+	switch finishReason {
+	case "end_turn", "stop_sequence":
+		finishReason = "stop"
+	case "max_tokens":
+		finishReason = "length"
+	}
+
+	completion := map[string]interface{}{
+		"id":     message["id"],
+		"object": "chat.completion",
+		"model":  message["model"],
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index":         0,
+				"message":       assistant,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+
+	if usage, ok := message["usage"].(map[string]interface{}); ok {
+		input, _ := usage["input_tokens"].(float64)
+		output, _ := usage["output_tokens"].(float64)
+		completion["usage"] = map[string]interface{}{
+			"prompt_tokens":     int(input),
+			"completion_tokens": int(output),
+			"total_tokens":      int(input + output),
+		}
+	}
+	return completion
+}
+
+// CancelBatchTask 取消 Message Batch
+func (am *AnthropicBatchManager) CancelBatchTask(batchID string) (map[string]interface{}, error) {
+	return am.do("POST", "/messages/batches/"+batchID+"/cancel", nil)
+}
+
+// DeleteFile Anthropic 没有远端文件，直接返回成功
+func (am *AnthropicBatchManager) DeleteFile(fileID string) (map[string]interface{}, error) {
+	return map[string]interface{}{"id": fileID, "deleted": true}, nil
+}
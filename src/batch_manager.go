@@ -7,33 +7,41 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strings"
 )
 
-// BatchManager 批处理管理器
+// BatchManager 批处理管理器（OpenAI 兼容的 /v1/files、/v1/batches 接口）
 type BatchManager struct {
+	baseURL  string
 	password string
 	header   map[string]string
 }
 
-// NewBatchManager 创建批处理管理器
-func NewBatchManager() *BatchManager {
+// NewBatchManager 创建批处理管理器，baseURL 形如 https://host/v1
+func NewBatchManager(baseURL string) *BatchManager {
 	header := make(map[string]string)
 	header["Authorization"] = "Bearer " + ModelConf.Password
 	header["Content-Type"] = "application/json"
 
 	return &BatchManager{
+		baseURL:  strings.TrimRight(baseURL, "/"),
 		password: ModelConf.Password,
 		header:   header,
 	}
 }
 
+// url 拼接接口地址
+func (bm *BatchManager) url(format string, a ...interface{}) string {
+	return bm.baseURL + fmt.Sprintf(format, a...)
+}
+
 // UploadFile 上传文件获取链接
 func (bm *BatchManager) UploadFile(filePath string) (string, error) {
-	url := "https://spark-api-open.xf-yun.com/v1/files"
+	url := bm.url("/files")
 
-	file, err := os.Open(filePath)
+	// 压缩的分块文件解压后上传
+	file, err := openFile(filePath)
 	if err != nil {
 		return "", err
 	}
@@ -46,7 +54,7 @@ func (bm *BatchManager) UploadFile(filePath string) (string, error) {
 	writer.WriteField("purpose", "batch")
 
 	// 添加文件字段
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+	part, err := writer.CreateFormFile("file", filepath.Base(trimCompressionExt(filePath)))
 	if err != nil {
 		return "", err
 	}
@@ -100,9 +108,9 @@ func (bm *BatchManager) UploadFile(filePath string) (string, error) {
 func (bm *BatchManager) GetFiles(fileID *string) (map[string]interface{}, error) {
 	var url string
 	if fileID == nil {
-		url = "https://spark-api-open.xf-yun.com/v1/files"
+		url = bm.url("/files")
 	} else {
-		url = fmt.Sprintf("https://spark-api-open.xf-yun.com/v1/files/%s", *fileID)
+		url = bm.url("/files/%s", *fileID)
 	}
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -134,37 +142,25 @@ func (bm *BatchManager) GetFiles(fileID *string) (map[string]interface{}, error)
 	return result, nil
 }
 
-// GetFileContent 获取文件内容
-func (bm *BatchManager) GetFileContent(fileID string) (string, error) {
-	url := fmt.Sprintf("https://spark-api-open.xf-yun.com/v1/files/%s/content", fileID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", err
-	}
+// DownloadFile 流式下载文件内容到 destPath（支持断点续传，完整后原子重命名）
+func (bm *BatchManager) DownloadFile(fileID string, destPath string) error {
+	url := bm.url("/files/%s/content", fileID)
 
-	for k, v := range bm.header {
-		req.Header.Set(k, v)
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return string(respBody), nil
+	return downloadToFile(func() (*http.Request, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range bm.header {
+			req.Header.Set(k, v)
+		}
+		return req, nil
+	}, destPath)
 }
 
 // DeleteFile 删除文件
 func (bm *BatchManager) DeleteFile(fileID string) (map[string]interface{}, error) {
-	url := fmt.Sprintf("https://spark-api-open.xf-yun.com/v1/files/%s", fileID)
+	url := bm.url("/files/%s", fileID)
 
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -197,7 +193,7 @@ func (bm *BatchManager) DeleteFile(fileID string) (map[string]interface{}, error
 
 // CreateBatchTask 创建任务并返回taskid
 func (bm *BatchManager) CreateBatchTask(inputFileID string) (string, error) {
-	url := "https://spark-api-open.xf-yun.com/v1/batches"
+	url := bm.url("/batches")
 
 	body := map[string]interface{}{
 		"input_file_id":     inputFileID,
@@ -250,7 +246,7 @@ func (bm *BatchManager) CreateBatchTask(inputFileID string) (string, error) {
 
 // CancelBatchTask 取消批量任务
 func (bm *BatchManager) CancelBatchTask(batchID string) (map[string]interface{}, error) {
-	url := fmt.Sprintf("https://spark-api-open.xf-yun.com/v1/batches/%s/cancel", batchID)
+	url := bm.url("/batches/%s/cancel", batchID)
 
 	req, err := http.NewRequest("POST", url, nil)
 	if err != nil {
@@ -283,7 +279,7 @@ func (bm *BatchManager) CancelBatchTask(batchID string) (map[string]interface{},
 
 // QueryBatchTask 查询批量任务状态
 func (bm *BatchManager) QueryBatchTask(batchID string) (map[string]interface{}, error) {
-	url := fmt.Sprintf("https://spark-api-open.xf-yun.com/v1/batches/%s", batchID)
+	url := bm.url("/batches/%s", batchID)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
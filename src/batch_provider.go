@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+)
+
+// 支持的批处理服务提供方
+const (
+	ProviderSpark     = "spark"     // 讯飞星火 batch 接口（默认）
+	ProviderOpenAI    = "openai"    // 任意 OpenAI 兼容的 /v1/batches 接口，需配置 base_url
+	ProviderAnthropic = "anthropic" // Anthropic Message Batches 接口（请求内联提交，结果按 custom_id 返回）
+)
+
+// defaultSparkBaseURL 星火 batch 接口默认地址
+const defaultSparkBaseURL = "https://spark-api-open.xf-yun.com/v1"
+
+// BatchProvider 批处理服务提供方，ChunkManager 只通过该接口与远端交互
+type BatchProvider interface {
+	// UploadFile 上传文件块，返回远端文件id
+	UploadFile(filePath string) (string, error)
+	// CreateBatchTask 基于已上传文件创建batch任务，返回batch id
+	CreateBatchTask(inputFileID string) (string, error)
+	// GetResult 查询batch任务状态，状态不在有效范围内时返回 nil, nil
+	GetResult(batchID string) (*BatchTaskInfo, error)
+	// DownloadFile 下载结果文件到 destPath，只有完整下载后 destPath 才会出现
+	DownloadFile(fileID string, destPath string) error
+	// CancelBatchTask 取消batch任务
+	CancelBatchTask(batchID string) (map[string]interface{}, error)
+	// DeleteFile 删除远端文件
+	DeleteFile(fileID string) (map[string]interface{}, error)
+}
+
+var _ BatchProvider = (*BatchManager)(nil)
+
+// validateProvider 校验 provider 与 base_url 配置
+func validateProvider(conf ModelConfig) error {
+	switch conf.Provider {
+	case "", ProviderSpark, ProviderAnthropic:
+		return nil
+	case ProviderOpenAI:
+		if conf.BaseURL == "" {
+			return fmt.Errorf("配置文件中 provider 为 %s 时 base_url 不能为空", conf.Provider)
+		}
+		return nil
+	default:
+		return fmt.Errorf("配置文件中 provider 不支持: %s", conf.Provider)
+	}
+}
+
+// NewBatchProvider 根据配置创建批处理服务提供方（配置已由 LoadConfig 校验）
+func NewBatchProvider() BatchProvider {
+	switch ModelConf.Provider {
+	case ProviderOpenAI:
+		return NewBatchManager(ModelConf.BaseURL)
+	case ProviderAnthropic:
+		baseURL := defaultAnthropicBaseURL
+		if ModelConf.BaseURL != "" {
+			baseURL = ModelConf.BaseURL
+		}
+		return NewAnthropicBatchManager(baseURL)
+	default:
+		baseURL := defaultSparkBaseURL
+		if ModelConf.BaseURL != "" {
+			baseURL = ModelConf.BaseURL
+		}
+		return NewBatchManager(baseURL)
+	}
+}
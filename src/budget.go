@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 每条消息与每个请求的固定 token 开销（角色、分隔符等）
+const (
+	messageTokenOverhead = 4
+	requestTokenOverhead = 3
+)
+
+// estimateMessagesTokens 估算一组 messages 的输入 token 数
+func estimateMessagesTokens(messages []interface{}) int {
+	tokens := requestTokenOverhead
+	for _, message := range messages {
+		tokens += messageTokenOverhead
+		msgMap, ok := message.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tokens += estimateContentTokens(msgMap["content"])
+	}
+	return tokens
+}
+
+// estimateContentTokens 估算消息内容的 token 数：字符串直接计算，多段内容累加各段的 text，其他类型按 JSON 计算
+func estimateContentTokens(content interface{}) int {
+	switch v := content.(type) {
+	case nil:
+		return 0
+	case string:
+		return TokenCounter.CountTokens(v)
+	case []interface{}:
+		tokens := 0
+		for _, part := range v {
+			if partMap, ok := part.(map[string]interface{}); ok {
+				if text, ok := partMap["text"].(string); ok {
+					tokens += TokenCounter.CountTokens(text)
+					continue
+				}
+			}
+			data, _ := json.Marshal(part)
+			tokens += TokenCounter.CountTokens(string(data))
+		}
+		return tokens
+	default:
+		data, _ := json.Marshal(v)
+		return TokenCounter.CountTokens(string(data))
+	}
+}
+
+// estimateRequestLine 估算一行batch请求的用量：输入按 messages（Anthropic 含 system）估算，输出按 max_tokens 计最坏情况
+func estimateRequestLine(line string) TokenUsage {
+	var request struct {
+		Body   map[string]interface{} `json:"body"`
+		Params map[string]interface{} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(line), &request); err != nil {
+		return TokenUsage{}
+	}
+
+	body := request.Body
+	if body == nil {
+		body = request.Params
+	}
+	messages, _ := body["messages"].([]interface{})
+	input := estimateMessagesTokens(messages)
+	if system, ok := body["system"]; ok {
+		input += estimateContentTokens(system)
+	}
+	maxTokens, _ := body["max_tokens"].(float64)
+	return TokenUsage{PromptTokens: int64(input), CompletionTokens: int64(maxTokens)}
+}
+
+// checkBudget 检查用量是否超出 budget 配置，超出时返回原因
+func checkBudget(model string, usage TokenUsage) error {
+	pricing, budget := currentBudget()
+	if budget.MaxInputTokens > 0 && usage.PromptTokens > budget.MaxInputTokens {
+		return fmt.Errorf("输入token %d 超出预算 max_input_tokens=%d", usage.PromptTokens, budget.MaxInputTokens)
+	}
+	if budget.MaxCost > 0 {
+		if cost, ok := pricing.EstimateCost(model, usage); ok && cost > budget.MaxCost {
+			return fmt.Errorf("预估费用 %.4f %s 超出预算 max_cost=%.4f（输入token %d，最大输出token %d）",
+				cost, pricing.Currency, budget.MaxCost, usage.PromptTokens, usage.CompletionTokens)
+		}
+	}
+	return nil
+}
@@ -2,17 +2,17 @@ package main
 
 // ChunkManager Chunk管理器
 type ChunkManager struct {
-	dbManager    *DBManager
-	fileManager  *FileManager
-	batchManager *BatchManager
+	dbManager   *DBManager
+	fileManager *FileManager
+	provider    BatchProvider
 }
 
 // NewChunkManager 创建Chunk管理器
-func NewChunkManager(dbManager *DBManager, fileManager *FileManager, batchManager *BatchManager) *ChunkManager {
+func NewChunkManager(dbManager *DBManager, fileManager *FileManager, provider BatchProvider) *ChunkManager {
 	return &ChunkManager{
-		dbManager:    dbManager,
-		fileManager:  fileManager,
-		batchManager: batchManager,
+		dbManager:   dbManager,
+		fileManager: fileManager,
+		provider:    provider,
 	}
 }
 
@@ -27,7 +27,7 @@ func (cm *ChunkManager) UploadChunk(chunkID string, fileData []byte) bool {
 
 	// 上传文件，完成后直接更新为已上传状态
 	if chunk.UploadFileID == nil {
-		uploadFileID, err := cm.batchManager.UploadFile(chunk.ChunkPath)
+		uploadFileID, err := cm.provider.UploadFile(chunk.ChunkPath)
 		if err != nil {
 			logError("上传文件块失败: %v", err)
 			errorMsg := err.Error()
@@ -35,11 +35,12 @@ func (cm *ChunkManager) UploadChunk(chunkID string, fileData []byte) bool {
 			return false
 		}
 
-		if err := cm.dbManager.UpdateChunkUploadFileID(chunkID, uploadFileID); err != nil {
+		// upload_file_id 与已上传状态在同一事务中写入
+		if err := cm.dbManager.MarkChunkUploaded(chunkID, uploadFileID); err != nil {
 			logError("更新upload_file_id失败: %v", err)
 			return false
 		}
-		chunk.UploadFileID = &uploadFileID
+		return true
 	}
 
 	if err := cm.dbManager.UpdateChunkStatus(chunkID, ChunkStatusUploaded, nil); err != nil {
@@ -68,22 +69,18 @@ func (cm *ChunkManager) ChunkStartProcess(chunkID string) bool {
 		return true
 	}
 
-	batchID, err := cm.batchManager.CreateBatchTask(*chunk.UploadFileID)
+	batchID, err := cm.provider.CreateBatchTask(*chunk.UploadFileID)
 	if err != nil {
 		logError("创建batch任务失败: %v", err)
 		return false
 	}
 
-	if err := cm.dbManager.UpdateChunkBatchID(chunkID, batchID); err != nil {
+	// batch_id 与处理中状态在同一事务中写入
+	if err := cm.dbManager.MarkChunkProcessing(chunkID, batchID); err != nil {
 		logError("更新batch_id失败: %v", err)
 		return false
 	}
 
-	if err := cm.dbManager.UpdateChunkStatus(chunkID, ChunkStatusProcessing, nil); err != nil {
-		logError("更新chunk状态失败: %v", err)
-		return false
-	}
-
 	return true
 }
 
@@ -104,7 +101,7 @@ func (cm *ChunkManager) CheckChunkProcess(chunkID string) bool {
 		return true
 	}
 
-	result, err := cm.batchManager.GetResult(*chunk.BatchID)
+	result, err := cm.provider.GetResult(*chunk.BatchID)
 	if err != nil {
 		logError("获取batch结果失败: %v", err)
 		return false
@@ -124,17 +121,18 @@ func (cm *ChunkManager) CheckChunkProcess(chunkID string) bool {
 	}
 
 	if result.IsFinished() {
+		// 结果文件必须完整下载后才能标记为已处理，否则下次检查时继续（续传）下载
 		if result.OutputFileID != "" {
-			content, err := cm.batchManager.GetFileContent(result.OutputFileID)
-			if err == nil {
-				cm.fileManager.SaveFile(chunk.TaskID, chunk.ChunkID, content, false)
+			if err := cm.provider.DownloadFile(result.OutputFileID, ResultFilePath(chunk, false)); err != nil {
+				logError("下载output文件失败: chunk_id=%s, %v", chunkID, err)
+				return false
 			}
 		}
 
 		if result.ErrorFileID != nil && *result.ErrorFileID != "" {
-			content, err := cm.batchManager.GetFileContent(*result.ErrorFileID)
-			if err == nil {
-				cm.fileManager.SaveFile(chunk.TaskID, chunk.ChunkID, content, true)
+			if err := cm.provider.DownloadFile(*result.ErrorFileID, ResultFilePath(chunk, true)); err != nil {
+				logError("下载error文件失败: chunk_id=%s, %v", chunkID, err)
+				return false
 			}
 		}
 
@@ -142,6 +140,9 @@ func (cm *ChunkManager) CheckChunkProcess(chunkID string) bool {
 			logError("更新chunk状态失败: %v", err)
 			return false
 		}
+		if err := cm.dbManager.UpdateChunkRecordsProcessed(chunkID); err != nil {
+			logError("更新chunk %s 的逐行状态失败: %v", chunkID, err)
+		}
 
 		return true
 	}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 支持的压缩方式
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// compressionExts 压缩方式对应的文件扩展名
+var compressionExts = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// validateCompression 校验配置中的压缩方式
+func validateCompression(compression string) error {
+	switch compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("不支持的压缩方式: %s（可选 gzip、zstd）", compression)
+	}
+}
+
+// compressionOf 按扩展名判断文件的压缩方式
+func compressionOf(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return CompressionGzip
+	case strings.HasSuffix(path, ".zst"), strings.HasSuffix(path, ".zstd"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// withCompressionExt 按压缩方式给路径追加扩展名
+func withCompressionExt(path string, compression string) string {
+	return path + compressionExts[compression]
+}
+
+// trimCompressionExt 去掉路径中的压缩扩展名，如 data.jsonl.gz -> data.jsonl
+func trimCompressionExt(path string) string {
+	for _, ext := range []string{".gz", ".zst", ".zstd"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// compressedReader 关闭时同时关闭解压器和底层文件
+type compressedReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (r *compressedReader) Close() error {
+	return closeAll(r.closers)
+}
+
+// openFile 打开文件，.gz / .zst 文件边读边解压
+func openFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch compressionOf(path) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("解压 %s 失败: %v", path, err)
+		}
+		return &compressedReader{Reader: gz, closers: []func() error{gz.Close, file.Close}}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("解压 %s 失败: %v", path, err)
+		}
+		return &compressedReader{Reader: zr, closers: []func() error{func() error { zr.Close(); return nil }, file.Close}}, nil
+	default:
+		return file, nil
+	}
+}
+
+// compressedWriter 关闭时先刷新压缩器再关闭底层文件
+type compressedWriter struct {
+	io.Writer
+	closers []func() error
+}
+
+func (w *compressedWriter) Close() error {
+	return closeAll(w.closers)
+}
+
+// closeAll 依次关闭，返回第一个错误
+func closeAll(closers []func() error) error {
+	var firstErr error
+	for _, closeFn := range closers {
+		if err := closeFn(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// createFile 创建（覆盖）文件，.gz / .zst 文件边写边压缩
+func createFile(path string) (io.WriteCloser, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch compressionOf(path) {
+	case CompressionGzip:
+		gz := gzip.NewWriter(file)
+		return &compressedWriter{Writer: gz, closers: []func() error{gz.Close, file.Close}}, nil
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &compressedWriter{Writer: zw, closers: []func() error{zw.Close, file.Close}}, nil
+	default:
+		return file, nil
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,16 +17,59 @@ var (
 	MERGED_DIR       string
 	DB_PATH          string
 	LOG_DIR          string
+	CACHE_DIR        string
+	UPLOAD_DIR       string
 )
 
 var (
 	TEST_LINES      = -1    // -1 不进行测试，其他数字为测试行数
 	LINES_PER_CHUNK = 50000 // 默认每个分块50000行，不能超过这个值
 	MAX_RETRY_COUNT = 0     // 最大重试次数（默认0，实际值从文件表的max_retry字段读取）
+
+	// RETRY_ERROR_CATEGORIES 需要重试的错误分类，其余分类的失败直接写入 terminal_errors.jsonl
+	RETRY_ERROR_CATEGORIES = map[ErrorCategory]bool{}
+
+	CACHE_ENABLED = false // 是否启用按请求哈希的结果缓存
+	SORT_OUTPUT   = false // 最终 output.jsonl 是否按原始输入顺序排序
+	CUSTOM_ID_KEY = ""    // custom_id 取自输入记录的字段，为空时使用行号
+
+	COMPRESS_OUTPUT = CompressionNone // 分块文件与合并结果的压缩方式：空（不压缩）、gzip、zstd
 )
 
+// JoinConf 结果关联回原始输入记录的配置
+var JoinConf = JoinConfig{
+	ContentKey:      "output",
+	ReasoningKey:    "reasoning_content",
+	UsageKey:        "usage",
+	FinishReasonKey: "finish_reason",
+	ErrorKey:        "error",
+}
+
+// ExportConf 最终合并后导出扁平表格的配置
+var ExportConf ExportConfig
+
+// PricingConf 各模型单价，用于估算任务费用
+var PricingConf PricingConfig
+
+// BudgetConf 任务预算，超出时拒绝启动或暂停重试
+var BudgetConf BudgetConfig
+
+// budgetMu 保护 PricingConf 与 BudgetConf：-resume 会在守护进程运行中重新加载它们
+var budgetMu sync.RWMutex
+
+// configFilePath LoadConfig 实际读取的配置文件，重新加载预算时使用
+var configFilePath string
+
+// ServeConf -serve 模式的 HTTP 接口配置
+var ServeConf ServeConfig
+
+// TokenCounter 分割时估算输入 token 数的分词器
+var TokenCounter Tokenizer = &charRatioTokenizer{charsPerToken: 4}
+
 // ModelConfig Model 配置结构
 type ModelConfig struct {
+	Provider       string                 `yaml:"provider"` // 批处理服务提供方：spark（默认）、openai、anthropic
+	BaseURL        string                 `yaml:"base_url"` // 接口地址，如 https://host/v1，为空时使用 provider 默认地址
 	Domain         string                 `yaml:"domain"`
 	MaxTokens      int                    `yaml:"max_tokens"`
 	MessagesKey    string                 `yaml:"messages_key"`
@@ -36,19 +80,151 @@ type ModelConfig struct {
 	ExtraBody      map[string]interface{} `yaml:"extra_body"`
 }
 
+// CacheConfig 结果缓存配置
+type CacheConfig struct {
+	Enabled bool   `yaml:"enabled"` // 是否启用结果缓存
+	Dir     string `yaml:"dir"`     // 缓存目录，默认为程序目录下的 cache
+}
+
+// JoinConfig 将模型输出写回原始输入记录（joined_output.jsonl）的配置，key 为空时使用默认值
+type JoinConfig struct {
+	Enabled         bool   `yaml:"enabled"`           // 最终合并时是否生成 joined_output.jsonl
+	ContentKey      string `yaml:"content_key"`       // 模型回复内容，默认 output
+	ReasoningKey    string `yaml:"reasoning_key"`     // 思考内容，默认 reasoning_content
+	UsageKey        string `yaml:"usage_key"`         // token 用量，默认 usage
+	FinishReasonKey string `yaml:"finish_reason_key"` // 结束原因，默认 finish_reason
+	ErrorKey        string `yaml:"error_key"`         // 失败或缺失行的错误信息，默认 error
+}
+
+// ExportConfig 最终合并后将结果导出为扁平表格（output_table.csv / output_table.parquet）的配置
+type ExportConfig struct {
+	Formats []string `yaml:"formats"` // 导出格式：csv、parquet，为空时不导出
+	Columns []string `yaml:"columns"` // 表格中附带的原始输入字段
+}
+
+// ModelPrice 模型单价（每百万 token），思考 token 按输出计价
+type ModelPrice struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// PricingConfig 费用估算配置
+type PricingConfig struct {
+	Currency string                `yaml:"currency"` // 币种，仅用于显示
+	Models   map[string]ModelPrice `yaml:"models"`   // 模型（domain）到单价的映射
+}
+
+// EstimateCost 按模型单价估算费用，未配置该模型的单价时返回 false
+func (p PricingConfig) EstimateCost(model string, usage TokenUsage) (float64, bool) {
+	price, ok := p.Models[model]
+	if !ok {
+		return 0, false
+	}
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6, true
+}
+
+// TokenizerConfig 估算输入 token 数的分词器配置
+type TokenizerConfig struct {
+	Type          string  `yaml:"type"`            // char_ratio（默认）或 bpe
+	CharsPerToken float64 `yaml:"chars_per_token"` // char_ratio：每个 token 对应的 ASCII 字符数，默认 4，非 ASCII 字符每个计 1 个 token
+	VocabFile     string  `yaml:"vocab_file"`      // bpe：本地 tiktoken 格式词表文件，如 cl100k_base.tiktoken
+}
+
+// BudgetConfig 任务预算，0 表示不限制
+type BudgetConfig struct {
+	MaxInputTokens int64   `yaml:"max_input_tokens"` // 预估输入 token 上限
+	MaxCost        float64 `yaml:"max_cost"`         // 预估最坏费用上限（输出按 max_tokens 计），需要在 pricing 中配置当前模型单价
+}
+
+// ServeConfig -serve 模式的 HTTP 接口配置
+type ServeConfig struct {
+	Token               string   `yaml:"token"`                 // 访问令牌，请求头需要携带 Authorization: Bearer <token>，为空时只允许监听本机回环地址
+	UploadDir           string   `yaml:"upload_dir"`            // 上传的输入文件保存目录，默认为程序目录下的 uploads
+	InputDirs           []string `yaml:"input_dirs"`            // 允许通过 file_path 提交的服务器目录，为空时只能上传文件提交
+	ExposeRejectedInput bool     `yaml:"expose_rejected_input"` // 是否允许下载 rejected_input.jsonl（包含输入行原文），默认不允许
+	MaxUploadBytes      int64    `yaml:"max_upload_bytes"`      // 上传请求体的最大字节数，超出时返回 413，默认 10 GiB
+}
+
 // Config 配置结构
 type Config struct {
 	Model         ModelConfig `yaml:"model"`
 	TestLines     *int        `yaml:"test_lines"`      // -1 不进行测试，其他数字为测试行数
 	MaxRetryCount *int        `yaml:"max_retry_count"` // 最大重试次数（默认0，实际值从文件表的max_retry字段读取）
 	LinesPerChunk *int        `yaml:"lines_per_chunk"` // 默认每个分块50000行，不能超过这个值
+
+	SortOutput           *bool    `yaml:"sort_output"`            // 最终 output.jsonl 是否按原始输入顺序排序，默认 false
+	CompressOutput       string   `yaml:"compress_output"`        // 分块文件与合并结果的压缩方式：gzip、zstd，为空时不压缩
+	CustomIDKey          string   `yaml:"custom_id_key"`          // custom_id 取自输入记录的字段（如 id、uuid），为空时使用行号
+	RetryErrorCategories []string `yaml:"retry_error_categories"` // 需要重试的错误分类，默认 rate_limit、server_error、timeout、unknown
+
+	Cache CacheConfig `yaml:"cache"` // 结果缓存
+
+	Join JoinConfig `yaml:"join"` // 结果关联回原始输入记录
+
+	Export ExportConfig `yaml:"export"` // 导出扁平表格
+
+	Pricing PricingConfig `yaml:"pricing"` // 模型单价，用于估算费用
+
+	Tokenizer TokenizerConfig `yaml:"tokenizer"` // 分割时估算输入 token 数的分词器
+
+	Budget BudgetConfig `yaml:"budget"` // 任务预算
+
+	PromptTemplate *PromptTemplateConfig `yaml:"prompt_template"` // 由输入记录字段渲染 messages，配置后不再读取 messages_key
+
+	Serve ServeConfig `yaml:"serve"` // -serve 模式的 HTTP 接口
 }
 
 // model 配置变量（从 YAML 文件加载）
 var (
-	ModelConf ModelConfig
+	ModelConf  ModelConfig
+	PromptTmpl *PromptTemplate // 未配置 prompt_template 时为 nil
 )
 
+// readConfigFile 读取并解析配置文件
+func readConfigFile(configPath string) (*Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	return &config, nil
+}
+
+// setBudgetConfig 校验并设置模型单价与预算
+func setBudgetConfig(config *Config) error {
+	if config.Budget.MaxCost > 0 {
+		if _, ok := config.Pricing.Models[ModelConf.Domain]; !ok {
+			return fmt.Errorf("配置文件中 budget.max_cost 需要在 pricing.models 中配置模型 %s 的单价", ModelConf.Domain)
+		}
+	}
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+	PricingConf = config.Pricing
+	BudgetConf = config.Budget
+	return nil
+}
+
+// ReloadBudgetConfig 重新读取配置文件中的 pricing 与 budget（其余配置不变），
+// 守护进程只在启动时加载一次配置，-resume 继续超出预算的任务前按修改后的预算重新检查
+func ReloadBudgetConfig() error {
+	config, err := readConfigFile(configFilePath)
+	if err != nil {
+		return err
+	}
+	return setBudgetConfig(config)
+}
+
+// currentBudget 返回当前的模型单价与预算
+func currentBudget() (PricingConfig, BudgetConfig) {
+	budgetMu.RLock()
+	defer budgetMu.RUnlock()
+	return PricingConf, BudgetConf
+}
+
 // LoadConfig 从 YAML 文件加载配置
 func LoadConfig(configPath string) error {
 	// 如果配置文件不存在，使用默认值
@@ -56,15 +232,11 @@ func LoadConfig(configPath string) error {
 		configPath = "./config.yaml"
 	}
 
-	data, err := os.ReadFile(configPath)
+	config, err := readConfigFile(configPath)
 	if err != nil {
-		return fmt.Errorf("读取配置文件失败: %v", err)
-	}
-
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("解析配置文件失败: %v", err)
+		return err
 	}
+	configFilePath = configPath
 
 	// 设置配置值
 	ModelConf = config.Model
@@ -73,7 +245,13 @@ func LoadConfig(configPath string) error {
 	if ModelConf.Domain == "" {
 		return fmt.Errorf("配置文件中 domain 不能为空")
 	}
-	if ModelConf.MessagesKey == "" {
+	if config.PromptTemplate != nil {
+		tmpl, err := NewPromptTemplate(*config.PromptTemplate)
+		if err != nil {
+			return fmt.Errorf("配置文件中 prompt_template 错误: %v", err)
+		}
+		PromptTmpl = tmpl
+	} else if ModelConf.MessagesKey == "" {
 		return fmt.Errorf("配置文件中 messages_key 不能为空")
 	}
 	if ModelConf.Password == "" {
@@ -82,6 +260,9 @@ func LoadConfig(configPath string) error {
 	if ModelConf.MaxTokens == 0 {
 		return fmt.Errorf("配置文件中 max_tokens 不能为0")
 	}
+	if err := validateProvider(ModelConf); err != nil {
+		return err
+	}
 
 	// 设置测试行数、最大重试次数、每块行数（如果配置文件中指定了）
 	if config.TestLines != nil {
@@ -96,6 +277,75 @@ func LoadConfig(configPath string) error {
 		}
 		LINES_PER_CHUNK = *config.LinesPerChunk
 	}
+	CUSTOM_ID_KEY = config.CustomIDKey
+	if err := validateCompression(config.CompressOutput); err != nil {
+		return fmt.Errorf("配置文件中 compress_output 错误: %v", err)
+	}
+	COMPRESS_OUTPUT = config.CompressOutput
+	if config.SortOutput != nil {
+		SORT_OUTPUT = *config.SortOutput
+	}
+	if config.RetryErrorCategories != nil {
+		categories, err := parseErrorCategories(config.RetryErrorCategories)
+		if err != nil {
+			return fmt.Errorf("配置文件中 retry_error_categories 错误: %v", err)
+		}
+		RETRY_ERROR_CATEGORIES = categories
+	} else {
+		for _, c := range DefaultRetryErrorCategories {
+			RETRY_ERROR_CATEGORIES[c] = true
+		}
+	}
+
+	CACHE_ENABLED = config.Cache.Enabled
+	if config.Cache.Dir != "" {
+		CACHE_DIR = config.Cache.Dir
+	}
+
+	JoinConf.Enabled = config.Join.Enabled
+	if config.Join.ContentKey != "" {
+		JoinConf.ContentKey = config.Join.ContentKey
+	}
+	if config.Join.ReasoningKey != "" {
+		JoinConf.ReasoningKey = config.Join.ReasoningKey
+	}
+	if config.Join.UsageKey != "" {
+		JoinConf.UsageKey = config.Join.UsageKey
+	}
+	if config.Join.FinishReasonKey != "" {
+		JoinConf.FinishReasonKey = config.Join.FinishReasonKey
+	}
+	if config.Join.ErrorKey != "" {
+		JoinConf.ErrorKey = config.Join.ErrorKey
+	}
+
+	if err := validateExportFormats(config.Export.Formats); err != nil {
+		return fmt.Errorf("配置文件中 export.formats 错误: %v", err)
+	}
+	if _, err := exportColumns(config.Export.Columns); err != nil {
+		return fmt.Errorf("配置文件中 export.columns 错误: %v", err)
+	}
+	ExportConf = config.Export
+
+	tokenizer, err := NewTokenizer(config.Tokenizer)
+	if err != nil {
+		return fmt.Errorf("配置文件中 tokenizer 错误: %v", err)
+	}
+	TokenCounter = tokenizer
+	if err := setBudgetConfig(config); err != nil {
+		return err
+	}
+
+	if config.Serve.MaxUploadBytes < 0 {
+		return fmt.Errorf("配置文件中 serve.max_upload_bytes 不能为负数: %d", config.Serve.MaxUploadBytes)
+	}
+	ServeConf = config.Serve
+	if config.Serve.UploadDir != "" {
+		UPLOAD_DIR = config.Serve.UploadDir
+	}
+	if ServeConf.MaxUploadBytes == 0 {
+		ServeConf.MaxUploadBytes = defaultMaxUploadBytes
+	}
 
 	logInfo("配置文件加载成功: %s", configPath)
 	return nil
@@ -116,6 +366,8 @@ func init() {
 	MERGED_DIR = filepath.Join(BASE_DIR, "merged")
 	DB_PATH = filepath.Join(BASE_DIR, "file_status.db")
 	LOG_DIR = filepath.Join(BASE_DIR, "log")
+	CACHE_DIR = filepath.Join(BASE_DIR, "cache")
+	UPLOAD_DIR = filepath.Join(BASE_DIR, "uploads")
 
 	// 创建必要的目录
 	os.MkdirAll(BATCH_RESULT_DIR, 0755)
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 守护进程控制接口：守护进程启动时监听本地 unix socket（Windows 或 socket 不可用时改为 127.0.0.1 随机端口），
+// 并将监听地址与访问令牌写入 .daemon.addr（仅当前用户可读），CLI 读取该文件后通过 HTTP/JSON 调用
+
+// controlTokenHeader 携带访问令牌的请求头
+const controlTokenHeader = "X-Control-Token"
+
+// controlRequestTimeout 单次控制请求的超时时间（提交任务需要等待分割完成，不受此限制）
+const controlRequestTimeout = 30 * time.Second
+
+// errDaemonUnavailable 守护进程未运行或控制接口无法连接，调用方应改为直接读写数据库
+var errDaemonUnavailable = errors.New("守护进程控制接口不可用")
+
+// 任务操作的错误，控制接口据此返回 400 / 404 / 409 / 403
+var (
+	errInvalidRequest = errors.New("请求参数错误")
+	errTaskNotFound   = errors.New("任务不存在")
+	errTaskState      = errors.New("任务状态不允许该操作")
+	errForbidden      = errors.New("不允许访问")
+	errTooLarge       = errors.New("请求体过大")
+)
+
+// controlAddrPath 守护进程控制接口地址文件
+func controlAddrPath() string {
+	return filepath.Join(BASE_DIR, ".daemon.addr")
+}
+
+// controlSocketPath 守护进程控制接口的 unix socket
+func controlSocketPath() string {
+	return filepath.Join(BASE_DIR, ".daemon.sock")
+}
+
+// ControlEndpoint .daemon.addr 的内容
+type ControlEndpoint struct {
+	Network string `json:"network"` // unix 或 tcp
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	PID     int    `json:"pid"`
+}
+
+// TaskStatus 控制接口返回的任务状态：文件信息（含各文件块）与状态摘要，以及费用与剩余时间估算
+type TaskStatus struct {
+	*FileInfo
+	Summary         *StatusSummary   `json:"summary"`
+	Cost            *float64         `json:"cost,omitempty"`           // 已产生用量的预估费用，未配置模型单价时为空
+	EstimatedCost   *float64         `json:"estimated_cost,omitempty"` // 分割时预估用量的最坏费用
+	Currency        string           `json:"currency,omitempty"`
+	ChunkETASeconds map[string]int64 `json:"chunk_eta_seconds"`     // 处理中各文件块batch的预计剩余秒数
+	ETASeconds      *int64           `json:"eta_seconds,omitempty"` // 当前一轮预计剩余秒数（处理中batch的最大值），不含之后的重试
+}
+
+// newTaskStatus 根据文件信息生成任务状态
+func newTaskStatus(fileInfo *FileInfo) *TaskStatus {
+	status := &TaskStatus{
+		FileInfo:        fileInfo,
+		Summary:         fileInfo.GetStatusSummary(),
+		ChunkETASeconds: make(map[string]int64),
+	}
+
+	model := usageModel(fileInfo)
+	pricing, _ := currentBudget()
+	if cost, ok := pricing.EstimateCost(model, fileInfo.Usage); ok {
+		status.Cost = &cost
+		status.Currency = pricing.Currency
+	}
+	if cost, ok := pricing.EstimateCost(model, fileInfo.EstimatedUsage); ok {
+		status.EstimatedCost = &cost
+	}
+
+	// 与 -monitor 相同：按 batch 开始时间与完成比例估算
+	for _, chunk := range fileInfo.Chunks {
+		info := chunk.BatchTaskInfo
+		if chunk.Status != ChunkStatusProcessing || info == nil || info.TotalCount == 0 ||
+			chunk.BatchStartTime == nil || *chunk.BatchStartTime == "" {
+			continue
+		}
+		startTime, err := time.ParseInLocation(time.DateTime, *chunk.BatchStartTime, time.Local)
+		if err != nil {
+			continue
+		}
+		remain, ok := remainSeconds(startTime, float64(info.CompletedCount+info.FailedCount)/float64(info.TotalCount))
+		if !ok {
+			continue
+		}
+		status.ChunkETASeconds[chunk.ChunkID] = remain
+		if status.ETASeconds == nil || remain > *status.ETASeconds {
+			status.ETASeconds = &remain
+		}
+	}
+	return status
+}
+
+// RecordsView 任务的逐行状态：各状态数量（取每条记录最新一轮）与未成功的记录
+type RecordsView struct {
+	Counts  map[string]int `json:"counts"`
+	Records []*Record      `json:"records"`
+}
+
+// SubmitRequest 提交任务的参数
+type SubmitRequest struct {
+	FilePath      string `json:"file_path"`                 // 守护进程所在机器上输入文件的绝对路径
+	TaskID        string `json:"task_id"`                   // 任务ID，不能与已有任务重复
+	InputFormat   string `json:"input_format,omitempty"`    // jsonl、csv、parquet，为空时按扩展名判断
+	LinesPerChunk *int   `json:"lines_per_chunk,omitempty"` // 为空时使用配置中的 lines_per_chunk
+}
+
+// controlErrorBody 控制接口的错误响应
+type controlErrorBody struct {
+	Error string `json:"error"`
+}
+
+// validateTaskID 任务ID会作为分块与结果目录名，不能为空或包含路径分隔符
+func validateTaskID(taskID string) error {
+	if strings.TrimSpace(taskID) == "" {
+		return fmt.Errorf("%w: task_id 不能为空", errInvalidRequest)
+	}
+	if strings.ContainsAny(taskID, `/\`) || taskID == "." || taskID == ".." {
+		return fmt.Errorf("%w: task_id 不能包含路径分隔符: %s", errInvalidRequest, taskID)
+	}
+	return nil
+}
+
+// controlAPI 控制接口的处理函数
+type controlAPI struct {
+	bis   *BatchInferService
+	stop  func() // 收到 /stop 时调用，为空表示不支持远程停止
+	serve bool   // 来自 -serve 的远程请求：接受 multipart 上传，file_path 只能位于 serve.input_dirs 中
+}
+
+// newControlHandler 创建守护进程控制接口的路由
+func (bis *BatchInferService) newControlHandler(stop func()) *http.ServeMux {
+	return (&controlAPI{bis: bis, stop: stop}).routes()
+}
+
+// routes 注册任务相关的路由
+func (api *controlAPI) routes() *http.ServeMux {
+	bis := api.bis
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", api.listTasks)
+	mux.HandleFunc("POST /tasks", api.submitTask)
+	mux.HandleFunc("GET /tasks/{id}", api.getTask)
+	mux.HandleFunc("GET /tasks/{id}/records", api.getRecords)
+	mux.HandleFunc("POST /tasks/{id}/start", api.startTask)
+	mux.HandleFunc("POST /tasks/{id}/cancel", api.taskAction(bis.CancelTask))
+	mux.HandleFunc("POST /tasks/{id}/pause", api.taskAction(bis.PauseTask))
+	mux.HandleFunc("POST /tasks/{id}/resume", api.taskAction(bis.ResumeTask))
+	if api.stop != nil {
+		mux.HandleFunc("POST /stop", api.stopDaemon)
+	}
+	return mux
+}
+
+// listTasks 列出所有任务，?active=1 时只列出分割完成与处理中的任务
+func (api *controlAPI) listTasks(w http.ResponseWriter, r *http.Request) {
+	var fileInfos []*FileInfo
+	var err error
+	if r.URL.Query().Get("active") != "" {
+		fileInfos, err = api.bis.loadActiveTasks()
+	} else {
+		fileInfos, err = api.bis.dbManager.GetAllFiles()
+	}
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+
+	statuses := make([]*TaskStatus, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		statuses = append(statuses, newTaskStatus(fileInfo))
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// getTask 查询单个任务
+func (api *controlAPI) getTask(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := api.bis.loadTask(r.PathValue("id"))
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newTaskStatus(fileInfo))
+}
+
+// getRecords 查询逐行状态：?custom_id= 时返回该记录在各轮中的状态；?chunk_id= 时返回该文件块中的失败记录；
+// 否则返回各状态数量与按原始行号排列的未成功记录。列表最多 ?limit= 条（默认 50，最多 1000）
+func (api *controlAPI) getRecords(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := api.bis.loadTask(r.PathValue("id"))
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := recordListLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > 1000 {
+			writeControlErrorStatus(w, http.StatusBadRequest, fmt.Errorf("%w: limit 需要在 1-1000 之间", errInvalidRequest))
+			return
+		}
+	}
+
+	view := RecordsView{}
+	if customID := query.Get("custom_id"); customID != "" {
+		view.Records, err = api.bis.dbManager.GetRecordHistory(fileInfo.TaskID, customID)
+	} else if chunkID := query.Get("chunk_id"); chunkID != "" {
+		found := false
+		for _, chunk := range fileInfo.Chunks {
+			found = found || chunk.ChunkID == chunkID
+		}
+		if !found {
+			writeControlErrorStatus(w, http.StatusNotFound, fmt.Errorf("文件块不属于任务 %s: %s", fileInfo.TaskID, chunkID))
+			return
+		}
+		view.Records, err = api.bis.dbManager.GetChunkErrorRecords(chunkID, limit)
+	} else {
+		view.Counts, err = api.bis.dbManager.CountRecords(fileInfo.TaskID)
+		if err == nil {
+			view.Records, err = api.bis.dbManager.GetUnfinishedRecords(fileInfo.TaskID, limit)
+		}
+	}
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	if view.Records == nil {
+		view.Records = []*Record{}
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// submitTask 分割输入文件创建任务，分割完成后立即开始调度。
+// JSON 请求体指定服务端文件路径；允许上传时也接受 multipart 表单（file、task_id、input_format、lines_per_chunk）
+func (api *controlAPI) submitTask(w http.ResponseWriter, r *http.Request) {
+	var req SubmitRequest
+	originalFilename := ""
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if !api.serve {
+			writeControlErrorStatus(w, http.StatusUnsupportedMediaType, errors.New("控制接口不接受上传，请指定 file_path"))
+			return
+		}
+		var err error
+		req, originalFilename, err = api.bis.saveUpload(w, r)
+		if err != nil {
+			writeControlError(w, err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeControlErrorStatus(w, http.StatusBadRequest, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	} else if api.serve {
+		if req.FilePath, err = resolveServeInputPath(req.FilePath); err != nil {
+			writeControlError(w, err)
+			return
+		}
+	}
+
+	fileInfo, err := api.bis.SubmitTask(req, originalFilename)
+	if err != nil {
+		if originalFilename != "" {
+			// 提交失败时删除本次上传创建的目录
+			os.RemoveAll(filepath.Dir(req.FilePath))
+		}
+		writeControlError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newTaskStatus(fileInfo))
+}
+
+// startTask 立即调度分割完成或处理中的任务，不必等待守护进程下一次扫描
+func (api *controlAPI) startTask(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := api.bis.loadTask(r.PathValue("id"))
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	if fileInfo.Status != FileStatusSplitCompleted && fileInfo.Status != FileStatusProcessing {
+		writeControlError(w, fmt.Errorf("%w: 当前状态 %s", errTaskState, fileInfo.Status))
+		return
+	}
+	go api.bis.ProcessFile(fileInfo.TaskID)
+	writeJSON(w, http.StatusOK, newTaskStatus(fileInfo))
+}
+
+// taskAction 包装取消、暂停、继续等任务操作；操作后任务可以继续处理时立即调度
+func (api *controlAPI) taskAction(action func(taskID string) (*FileInfo, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileInfo, err := action(r.PathValue("id"))
+		if err != nil {
+			writeControlError(w, err)
+			return
+		}
+		if fileInfo.Status == FileStatusProcessing {
+			go api.bis.ProcessFile(fileInfo.TaskID)
+		}
+		writeJSON(w, http.StatusOK, newTaskStatus(fileInfo))
+	}
+}
+
+// stopDaemon 停止守护进程，响应返回后再退出
+func (api *controlAPI) stopDaemon(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pid": os.Getpid()})
+	go api.stop()
+}
+
+// writeControlErrorStatus 写入控制接口的错误响应
+func writeControlErrorStatus(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, controlErrorBody{Error: err.Error()})
+}
+
+// writeControlError 按任务操作的错误类型选择状态码
+func writeControlError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidRequest):
+		writeControlErrorStatus(w, http.StatusBadRequest, err)
+	case errors.Is(err, errTaskNotFound):
+		writeControlErrorStatus(w, http.StatusNotFound, err)
+	case errors.Is(err, errTaskState):
+		writeControlErrorStatus(w, http.StatusConflict, err)
+	case errors.Is(err, errForbidden):
+		writeControlErrorStatus(w, http.StatusForbidden, err)
+	case errors.Is(err, errTooLarge):
+		writeControlErrorStatus(w, http.StatusRequestEntityTooLarge, err)
+	default:
+		writeControlErrorStatus(w, http.StatusInternalServerError, err)
+	}
+}
+
+// requireToken 校验请求头中的访问令牌
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(controlTokenHeader)), []byte(token)) != 1 {
+			writeControlErrorStatus(w, http.StatusUnauthorized, errors.New("访问令牌无效"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// controlServer 守护进程中运行的控制接口
+type controlServer struct {
+	server   *http.Server
+	endpoint ControlEndpoint
+}
+
+// startControlServer 启动控制接口并写入 .daemon.addr，stop 在收到 /stop 请求时调用
+func (bis *BatchInferService) startControlServer(stop func()) (*controlServer, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("生成访问令牌失败: %v", err)
+	}
+
+	listener, endpoint, err := listenControl()
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Token = hex.EncodeToString(tokenBytes)
+	endpoint.PID = os.Getpid()
+
+	data, err := json.Marshal(endpoint)
+	if err == nil {
+		err = os.WriteFile(controlAddrPath(), data, 0600)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("写入控制接口地址文件失败: %v", err)
+	}
+
+	cs := &controlServer{
+		server: &http.Server{
+			Handler:           requireToken(endpoint.Token, bis.newControlHandler(stop)),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		endpoint: endpoint,
+	}
+	go func() {
+		if err := cs.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logError("控制接口退出: %v", err)
+		}
+	}()
+	logInfo("控制接口已启动: %s %s", endpoint.Network, endpoint.Address)
+	return cs, nil
+}
+
+// listenControl 非 Windows 系统优先监听 unix socket，失败时（如路径过长）改为本机回环地址
+func listenControl() (net.Listener, ControlEndpoint, error) {
+	if runtime.GOOS != "windows" {
+		socketPath := controlSocketPath()
+		// 删除上次异常退出时遗留的 socket 文件
+		os.Remove(socketPath)
+		listener, err := net.Listen("unix", socketPath)
+		if err == nil {
+			os.Chmod(socketPath, 0600)
+			return listener, ControlEndpoint{Network: "unix", Address: socketPath}, nil
+		}
+		logInfo("监听 unix socket 失败，改用本机回环地址: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, ControlEndpoint{}, fmt.Errorf("监听控制接口失败: %v", err)
+	}
+	return listener, ControlEndpoint{Network: "tcp", Address: listener.Addr().String()}, nil
+}
+
+// Close 停止控制接口并删除地址文件与 socket 文件
+func (cs *controlServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cs.server.Shutdown(ctx)
+	os.Remove(controlAddrPath())
+	if cs.endpoint.Network == "unix" {
+		os.Remove(cs.endpoint.Address)
+	}
+}
+
+// ControlClient 守护进程控制接口的客户端
+type ControlClient struct {
+	endpoint ControlEndpoint
+	client   *http.Client
+}
+
+// newControlClient 读取 .daemon.addr 创建客户端，文件不存在时返回 errDaemonUnavailable
+func newControlClient() (*ControlClient, error) {
+	data, err := os.ReadFile(controlAddrPath())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDaemonUnavailable, err)
+	}
+	var endpoint ControlEndpoint
+	if err := json.Unmarshal(data, &endpoint); err != nil {
+		return nil, fmt.Errorf("%w: 地址文件格式错误: %v", errDaemonUnavailable, err)
+	}
+
+	dialer := &net.Dialer{Timeout: 3 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, endpoint.Network, endpoint.Address)
+		},
+	}
+	return &ControlClient{endpoint: endpoint, client: &http.Client{Transport: transport}}, nil
+}
+
+// do 发送请求并解析 JSON 响应；连接失败（守护进程已退出）时返回 errDaemonUnavailable
+func (c *ControlClient) do(method string, path string, timeout time.Duration, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// 连接由 DialContext 决定，URL 中的主机名只用于组装请求
+	req, err := http.NewRequestWithContext(ctx, method, "http://daemon"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(controlTokenHeader, c.endpoint.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return fmt.Errorf("%w: %v", errDaemonUnavailable, err)
+		}
+		return fmt.Errorf("请求守护进程失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errBody controlErrorBody
+		json.NewDecoder(resp.Body).Decode(&errBody)
+		return fmt.Errorf("守护进程返回 %d: %s", resp.StatusCode, errBody.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ListTasks 列出任务，active 为 true 时只列出分割完成与处理中的任务
+func (c *ControlClient) ListTasks(active bool) ([]*TaskStatus, error) {
+	path := "/tasks"
+	if active {
+		path += "?active=1"
+	}
+	var statuses []*TaskStatus
+	if err := c.do(http.MethodGet, path, controlRequestTimeout, nil, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// GetTask 查询单个任务
+func (c *ControlClient) GetTask(taskID string) (*TaskStatus, error) {
+	var status TaskStatus
+	if err := c.do(http.MethodGet, "/tasks/"+url.PathEscape(taskID), controlRequestTimeout, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Submit 提交任务，等待守护进程分割完成后返回
+func (c *ControlClient) Submit(req SubmitRequest) (*TaskStatus, error) {
+	var status TaskStatus
+	if err := c.do(http.MethodPost, "/tasks", 0, req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// TaskAction 执行任务操作：start、cancel、pause、resume
+func (c *ControlClient) TaskAction(taskID string, action string) (*TaskStatus, error) {
+	var status TaskStatus
+	path := "/tasks/" + url.PathEscape(taskID) + "/" + action
+	if err := c.do(http.MethodPost, path, controlRequestTimeout, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Stop 通知守护进程退出
+func (c *ControlClient) Stop() error {
+	return c.do(http.MethodPost, "/stop", controlRequestTimeout, nil, nil)
+}
+
+// runTaskAction 守护进程运行时通过控制接口执行任务操作，守护进程不可用时在本进程中执行 local
+func (bis *BatchInferService) runTaskAction(taskID string, action string, local func(taskID string) (*FileInfo, error)) (*FileInfo, error) {
+	if client, err := newControlClient(); err == nil {
+		status, err := client.TaskAction(taskID, action)
+		if !errors.Is(err, errDaemonUnavailable) {
+			if err != nil {
+				return nil, err
+			}
+			return status.FileInfo, nil
+		}
+	}
+	return local(taskID)
+}
+
+// fetchTask 读取任务状态：守护进程运行时通过控制接口读取，否则直接读数据库
+func (bis *BatchInferService) fetchTask(taskID string) (*FileInfo, error) {
+	if client, err := newControlClient(); err == nil {
+		status, err := client.GetTask(taskID)
+		if !errors.Is(err, errDaemonUnavailable) {
+			if err != nil {
+				return nil, err
+			}
+			return status.FileInfo, nil
+		}
+	}
+	return bis.loadTask(taskID)
+}
+
+// fetchActiveTasks 读取分割完成与处理中的任务：守护进程运行时通过控制接口读取，否则直接读数据库
+func (bis *BatchInferService) fetchActiveTasks() ([]*FileInfo, error) {
+	if client, err := newControlClient(); err == nil {
+		statuses, err := client.ListTasks(true)
+		if !errors.Is(err, errDaemonUnavailable) {
+			if err != nil {
+				return nil, err
+			}
+			fileInfos := make([]*FileInfo, 0, len(statuses))
+			for _, status := range statuses {
+				fileInfos = append(fileInfos, status.FileInfo)
+			}
+			return fileInfos, nil
+		}
+	}
+	return bis.loadActiveTasks()
+}
+
+// loadTask 从数据库读取任务，不存在时返回 errTaskNotFound
+func (bis *BatchInferService) loadTask(taskID string) (*FileInfo, error) {
+	fileInfo, err := bis.dbManager.GetFile(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo == nil {
+		return nil, fmt.Errorf("%w: %s", errTaskNotFound, taskID)
+	}
+	return fileInfo, nil
+}
+
+// loadTaskHeader 与 loadTask 相同，但不加载文件块，用于只检查任务状态或读取结果文件的请求
+func (bis *BatchInferService) loadTaskHeader(taskID string) (*FileInfo, error) {
+	fileInfo, err := bis.dbManager.GetFileHeader(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo == nil {
+		return nil, fmt.Errorf("%w: %s", errTaskNotFound, taskID)
+	}
+	return fileInfo, nil
+}
+
+// loadActiveTasks 从数据库读取分割完成与处理中的任务
+func (bis *BatchInferService) loadActiveTasks() ([]*FileInfo, error) {
+	return bis.dbManager.GetActiveFiles()
+}
+
+// StopDaemon 通过控制接口通知守护进程退出
+func StopDaemon() error {
+	client, err := newControlClient()
+	if err != nil {
+		return err
+	}
+	return client.Stop()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// anthropicCustomIDPattern Anthropic Message Batches 对 custom_id 的格式要求
+var anthropicCustomIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// recordCustomID 计算一条输入记录的 custom_id：未配置 custom_id_key 时为行号（从1开始），否则取记录中的字段值
+func recordCustomID(record map[string]interface{}, key string, lineNumber int) (string, error) {
+	if key == "" {
+		return fmt.Sprintf("%d", lineNumber), nil
+	}
+
+	var customID string
+	switch v := record[key].(type) {
+	case string:
+		customID = v
+	case json.Number:
+		// 保留输入中的原始文本，大整数不经过 float64
+		customID = v.String()
+	case nil:
+		return "", fmt.Errorf("缺少字段 %s", key)
+	default:
+		return "", fmt.Errorf("字段 %s 必须为字符串或数字", key)
+	}
+	if customID == "" {
+		return "", fmt.Errorf("字段 %s 不能为空", key)
+	}
+	if ModelConf.Provider == ProviderAnthropic && !anthropicCustomIDPattern.MatchString(customID) {
+		return "", fmt.Errorf("字段 %s 的值 %q 不符合 Anthropic custom_id 格式（1-64位字母、数字、_、-）", key, customID)
+	}
+	return customID, nil
+}
+
+// scanInputCustomIDs 按 SplitFile 的规则遍历会被提交（或命中缓存）的记录，依次回调 custom_id 与行号；
+// 字段缺失、为空等无法构建请求的行与分割时一样跳过（写入 rejected_input.jsonl），limit 见 forEachBatchRequest
+func scanInputCustomIDs(filePath string, format string, key string, limit int, fn func(customID string, lineNumber int) error) error {
+	return forEachBatchRequest(filePath, format, key, limit, func(request *batchRequest, _ map[string]interface{}, lineNumber int, _ string) error {
+		return fn(request.CustomID, lineNumber)
+	})
+}
+
+// CheckDuplicateCustomIDs 分割前检查会被提交的记录中 custom_id_key 对应的字段是否重复，重复时不创建任务
+func CheckDuplicateCustomIDs(filePath string, format string, key string) error {
+	seen := make(map[string]int)
+	return scanInputCustomIDs(filePath, format, key, TEST_LINES, func(customID string, lineNumber int) error {
+		if firstLine, ok := seen[customID]; ok {
+			return fmt.Errorf("custom_id 重复: %q（第%d行与第%d行）", customID, firstLine, lineNumber)
+		}
+		seen[customID] = lineNumber
+		return nil
+	})
+}
+
+// inputOrderSortKey 返回按原始输入顺序排序的排序键函数
+// 使用行号作为 custom_id 时直接按数值排序；使用自定义字段时先建立 custom_id 到行号的映射
+func inputOrderSortKey(fileInfo *FileInfo) (func(line string) sortKey, error) {
+	if fileInfo.CustomIDKey == "" {
+		return customIDSortKey, nil
+	}
+
+	lineNumbers := make(map[string]int)
+	err := scanInputCustomIDs(fileInfo.FilePath, fileInfo.InputFormat, fileInfo.CustomIDKey, fileInfo.TotalLines, func(customID string, lineNumber int) error {
+		lineNumbers[customID] = lineNumber
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(line string) sortKey {
+		customID := lineCustomID(line)
+		if lineNumber, ok := lineNumbers[customID]; ok {
+			return sortKey{num: int64(lineNumber), str: customID}
+		}
+		return sortKey{num: unknownSortNum, str: customID}
+	}, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles 内嵌的网页看板（-serve 下的 /），页面每 10 秒从 /api/ 读取任务状态
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler 返回看板静态文件，接口鉴权由 /api/ 负责，页面本身不含任务数据
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
@@ -0,0 +1,304 @@
+// 任务看板：每 10 秒从 /api/ 读取任务状态并刷新，数据与 -monitor 一致
+(function () {
+  "use strict";
+
+  var REFRESH_MS = 10000;
+  var CHUNK_STATES = ["pending", "uploaded", "processing", "processed", "upload_failed", "canceled"];
+  var RECORD_STATES = ["success", "cached", "pending", "processed", "failed", "terminal", "missing"];
+
+  var state = {
+    token: localStorage.getItem("batchInferToken") || "",
+    taskId: null,
+    chunkId: null,
+    timer: null
+  };
+
+  function $(id) { return document.getElementById(id); }
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (key) {
+      if (key === "text") node.textContent = attrs[key];
+      else if (key === "className") node.className = attrs[key];
+      else if (key === "title") node.title = attrs[key];
+      else if (key === "onclick") node.onclick = attrs[key];
+      else node.setAttribute(key, attrs[key]);
+    });
+    (children || []).forEach(function (child) {
+      node.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+    });
+    return node;
+  }
+
+  function td(content, className) {
+    var cell = el("td", { className: className || "" });
+    if (content instanceof Node) cell.appendChild(content);
+    else cell.textContent = content == null ? "" : String(content);
+    return cell;
+  }
+
+  function badge(status) {
+    return el("span", { className: "badge " + (status || ""), text: status || "-" });
+  }
+
+  function num(value) { return (value || 0).toLocaleString(); }
+
+  function duration(seconds) {
+    if (seconds == null) return "-";
+    var h = Math.floor(seconds / 3600), m = Math.floor((seconds % 3600) / 60), s = seconds % 60;
+    if (h > 0) return h + "h " + String(m).padStart(2, "0") + "m";
+    if (m > 0) return m + "m " + String(s).padStart(2, "0") + "s";
+    return s + "s";
+  }
+
+  function cost(value, currency) {
+    return value == null ? "-" : value.toFixed(4) + " " + (currency || "");
+  }
+
+  // 成功占比进度条，失败部分以红色显示
+  function progressBar(done, failed, total) {
+    var bar = el("div", { className: "bar" });
+    var pct = total > 0 ? Math.min(100, done / total * 100) : 0;
+    var failedPct = total > 0 ? Math.min(100 - pct, failed / total * 100) : 0;
+    bar.appendChild(el("span", { className: "fill-ok", style: "width:" + pct + "%" }));
+    bar.appendChild(el("span", { className: "fill-failed", style: "width:" + failedPct + "%" }));
+    bar.appendChild(el("div", { className: "label", text: Math.floor(pct) + "%  " + num(done) + " / " + num(total) }));
+    return bar;
+  }
+
+  // 各状态文件块数量的分段条
+  function chunkBar(total, count) {
+    var bar = el("div", { className: "bar" });
+    var titles = [];
+    CHUNK_STATES.forEach(function (status) {
+      var n = total[status] || 0;
+      if (n === 0 || count === 0) return;
+      bar.appendChild(el("span", { className: "seg-" + status, style: "width:" + (n / count * 100) + "%" }));
+      titles.push(status + ": " + n);
+    });
+    bar.appendChild(el("div", { className: "label", text: (total.processed || 0) + " / " + count }));
+    bar.title = titles.join("\n");
+    return bar;
+  }
+
+  function api(path) {
+    var headers = {};
+    if (state.token) headers.Authorization = "Bearer " + state.token;
+    return fetch("api" + path, { headers: headers }).then(function (resp) {
+      if (resp.status === 401) {
+        $("login").hidden = false;
+        throw new Error("Access token required");
+      }
+      return resp.json().then(function (body) {
+        if (!resp.ok) throw new Error(body.error || resp.statusText);
+        return body;
+      });
+    });
+  }
+
+  // 下载链接无法携带请求头，令牌通过查询参数传递
+  function downloadURL(path) {
+    return "api" + path + (state.token ? "?token=" + encodeURIComponent(state.token) : "");
+  }
+
+  function showError(err) {
+    $("error").hidden = !err;
+    $("error").textContent = err ? String(err.message || err) : "";
+  }
+
+  function chunkStatesByCount(task) {
+    var counts = {};
+    (task.chunks || []).forEach(function (chunk) {
+      counts[chunk.status] = (counts[chunk.status] || 0) + 1;
+    });
+    return counts;
+  }
+
+  function renderTasks(tasks) {
+    var body = $("tasks").querySelector("tbody");
+    body.textContent = "";
+    $("empty").hidden = tasks.length > 0;
+    tasks.forEach(function (task) {
+      var total = task.summary.total || {};
+      var row = el("tr", { className: task.task_id === state.taskId ? "selected" : "" });
+      row.onclick = function () { selectTask(task.task_id); };
+      row.appendChild(td(el("div", {}, [
+        el("strong", { text: task.task_id }),
+        el("div", { className: "muted", text: task.original_filename })
+      ])));
+      row.appendChild(td(badge(task.status)));
+      row.appendChild(td(progressBar(total.complete_count, total.failed_count, task.total_lines)));
+      row.appendChild(td(chunkBar(chunkStatesByCount(task), (task.chunks || []).length)));
+      row.appendChild(td(task.retry + " / " + task.max_retry));
+      row.appendChild(td(num(total.failed_count)));
+      row.appendChild(td(duration(task.eta_seconds)));
+      row.appendChild(td(num(task.usage.prompt_tokens) + " / " + num(task.usage.completion_tokens)));
+      row.appendChild(td(cost(task.cost, task.currency)));
+      row.appendChild(td(task.updated_time));
+      body.appendChild(row);
+    });
+  }
+
+  function card(name, value) {
+    return el("div", { className: "card" }, [
+      el("div", { className: "name", text: name }),
+      el("div", { className: "value", text: value })
+    ]);
+  }
+
+  function renderDetail(task) {
+    var total = task.summary.total || {};
+    $("detail").hidden = false;
+    $("detail-title").textContent = task.task_id + "  ·  " + task.original_filename;
+
+    var cards = $("cards");
+    cards.textContent = "";
+    cards.appendChild(el("div", { className: "card" }, [el("div", { className: "name", text: "Status" }), badge(task.status)]));
+    [
+      ["Lines", num(task.total_lines)],
+      ["Succeeded", num(total.complete_count)],
+      ["Failed", num(total.failed_count)],
+      ["Cache hits", num(task.cached_lines)],
+      ["Rejected at split", num(task.rejected_lines)],
+      ["Round", task.retry + " / " + task.max_retry],
+      ["ETA (current round)", duration(task.eta_seconds)],
+      ["Model", task.model || "-"],
+      ["Input tokens", num(task.usage.prompt_tokens)],
+      ["Output tokens", num(task.usage.completion_tokens) + " (reasoning " + num(task.usage.reasoning_tokens) + ")"],
+      ["Cost", cost(task.cost, task.currency)],
+      ["Estimated worst case", cost(task.estimated_cost, task.currency)],
+      ["Created", task.created_time],
+      ["Updated", task.updated_time]
+    ].forEach(function (item) { cards.appendChild(card(item[0], item[1])); });
+
+    $("detail-message").hidden = !task.error_message;
+    $("detail-message").textContent = task.error_message || "";
+
+    var rounds = $("rounds").querySelector("tbody");
+    rounds.textContent = "";
+    Object.keys(task.summary.by_retry || {}).sort(function (a, b) { return a - b; }).forEach(function (retry) {
+      var counts = task.summary.by_retry[retry];
+      var row = el("tr");
+      [retry, counts.pending, counts.uploaded, counts.processing, counts.processed, counts.upload_failed].forEach(function (value) {
+        row.appendChild(td(value));
+      });
+      rounds.appendChild(row);
+    });
+
+    var chunks = $("chunks").querySelector("tbody");
+    chunks.textContent = "";
+    (task.chunks || []).forEach(function (chunk) {
+      var info = chunk.batch_task_info || {};
+      var row = el("tr", { className: chunk.chunk_id === state.chunkId ? "selected" : "" });
+      row.onclick = function () { selectChunk(chunk.chunk_id); };
+      row.appendChild(td(chunk.chunk_id));
+      row.appendChild(td(chunk.retry));
+      row.appendChild(td(badge(chunk.status)));
+      row.appendChild(td(info.status ? badge(info.status) : "-"));
+      row.appendChild(td(info.total_count ? progressBar(info.completed_count, info.failed_count, info.total_count) : "-"));
+      row.appendChild(td(info.total_count ? num(info.failed_count) : "-"));
+      row.appendChild(td(duration((task.chunk_eta_seconds || {})[chunk.chunk_id])));
+      row.appendChild(td(num(chunk.usage.prompt_tokens) + " / " + num(chunk.usage.completion_tokens)));
+      row.appendChild(td(chunk.batch_start_time || "-"));
+      row.appendChild(td(chunk.error_message || "", "message"));
+      chunks.appendChild(row);
+    });
+  }
+
+  function renderRecords(view) {
+    var counts = $("record-counts");
+    counts.textContent = "";
+    if (view.counts) {
+      RECORD_STATES.forEach(function (status) {
+        if (!view.counts[status]) return;
+        counts.appendChild(el("span", { className: "badge " + status, text: status + ": " + num(view.counts[status]) }));
+      });
+    }
+    $("records-scope").textContent = state.chunkId
+      ? "(error samples in " + state.chunkId + ")"
+      : "(latest round of each record; showing failed, terminal and missing)";
+
+    var body = $("records").querySelector("tbody");
+    body.textContent = "";
+    $("records-empty").hidden = view.records.length > 0;
+    view.records.forEach(function (record) {
+      var row = el("tr");
+      row.appendChild(td(record.custom_id));
+      row.appendChild(td(record.source_line));
+      row.appendChild(td(record.retry));
+      row.appendChild(td(badge(record.status)));
+      row.appendChild(td(record.error_category || ""));
+      row.appendChild(td(record.error_message || "", "message"));
+      row.appendChild(td(record.chunk_id || ""));
+      body.appendChild(row);
+    });
+  }
+
+  function renderFiles(files) {
+    var list = $("files");
+    list.textContent = "";
+    if (files.length === 0) {
+      list.appendChild(el("li", { className: "muted", text: "No result files yet." }));
+      return;
+    }
+    files.forEach(function (file) {
+      var path = "/tasks/" + encodeURIComponent(state.taskId) + "/files/" + encodeURIComponent(file.name);
+      list.appendChild(el("li", {}, [
+        el("a", { href: downloadURL(path), text: file.name }),
+        el("span", { className: "muted", text: "  " + num(file.size) + " bytes · " + file.modified_time })
+      ]));
+    });
+  }
+
+  function loadDetail() {
+    if (!state.taskId) return Promise.resolve();
+    var id = encodeURIComponent(state.taskId);
+    var recordsPath = "/tasks/" + id + "/records" + (state.chunkId ? "?chunk_id=" + encodeURIComponent(state.chunkId) : "");
+    return Promise.all([api("/tasks/" + id), api(recordsPath), api("/tasks/" + id + "/files")]).then(function (results) {
+      renderDetail(results[0]);
+      renderRecords(results[1]);
+      renderFiles(results[2]);
+    });
+  }
+
+  function refresh() {
+    var path = "/tasks" + ($("active-only").checked ? "?active=1" : "");
+    return api(path).then(function (tasks) {
+      renderTasks(tasks);
+      $("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+      return loadDetail();
+    }).then(function () { showError(null); }, showError);
+  }
+
+  function selectTask(taskId) {
+    state.taskId = taskId;
+    state.chunkId = null;
+    location.hash = encodeURIComponent(taskId);
+    refresh();
+  }
+
+  function selectChunk(chunkId) {
+    state.chunkId = state.chunkId === chunkId ? null : chunkId;
+    loadDetail().catch(showError);
+  }
+
+  $("refresh").onclick = refresh;
+  $("active-only").onchange = refresh;
+  $("close-detail").onclick = function () {
+    state.taskId = null;
+    state.chunkId = null;
+    $("detail").hidden = true;
+    history.replaceState(null, "", location.pathname);
+    refresh();
+  };
+  $("save-token").onclick = function () {
+    state.token = $("token").value;
+    localStorage.setItem("batchInferToken", state.token);
+    $("login").hidden = true;
+    refresh();
+  };
+
+  if (location.hash.length > 1) state.taskId = decodeURIComponent(location.hash.slice(1));
+  refresh();
+  state.timer = setInterval(refresh, REFRESH_MS);
+})();
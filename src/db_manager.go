@@ -3,92 +3,137 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
-// DBManager 数据库管理器
+// DBManager 数据库管理器，进程内共用一个连接池
 type DBManager struct {
 	dbPath string
+	conn   *sql.DB
 }
 
-// NewDBManager 创建数据库管理器
+// NewDBManager 创建数据库管理器，并将数据库结构迁移到最新版本
 func NewDBManager() *DBManager {
-	db := &DBManager{dbPath: DB_PATH}
-	db.initDatabase()
+	db, err := openDBManager()
+	if err != nil {
+		logError("打开数据库失败: %v", err)
+		return db
+	}
+	if err := db.migrate(); err != nil {
+		logError("初始化数据库失败: %v", err)
+	}
 	return db
 }
 
-// getConnection 获取数据库连接
-func (db *DBManager) getConnection() (*sql.DB, error) {
-	conn, err := sql.Open("sqlite", db.dbPath+"?_timeout=10000")
+// dbMaxOpenConns 连接池中的最大连接数
+const dbMaxOpenConns = 4
+
+// openDBManager 打开数据库连接池，不执行迁移
+func openDBManager() (*DBManager, error) {
+	// WAL 模式下读写互不阻塞，守护进程与命令行进程同时访问时写锁冲突等待 busy_timeout；
+	// 事务开始时即获取写锁（_txlock=immediate），避免读锁升级为写锁时直接返回 SQLITE_BUSY
+	return openDBManagerDSN(DB_PATH + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate")
+}
+
+// openDBManagerReadOnly 以只读方式打开数据库，不设置 journal_mode 等会写入数据库文件的参数
+func openDBManagerReadOnly() (*DBManager, error) {
+	return openDBManagerDSN("file:" + DB_PATH + "?mode=ro&_pragma=busy_timeout(10000)")
+}
+
+// openDBManagerDSN 按连接串打开连接池
+func openDBManagerDSN(dsn string) (*DBManager, error) {
+	db := &DBManager{dbPath: DB_PATH}
+	conn, err := sql.Open("sqlite", dsn)
 	if err != nil {
-		return nil, err
+		return db, err
+	}
+
+	// WAL 模式下多个连接可以同时读，控制接口与监控的查询不必排在守护进程的写入之后；
+	// 写入仍由 SQLite 串行化：事务开始即获取写锁，冲突时等待 busy_timeout
+	conn.SetMaxOpenConns(dbMaxOpenConns)
+	conn.SetMaxIdleConns(dbMaxOpenConns)
+	db.conn = conn
+	return db, nil
+}
+
+// Close 关闭连接池
+func (db *DBManager) Close() error {
+	if db.conn == nil {
+		return nil
 	}
-	return conn, nil
+	return db.conn.Close()
 }
 
-// initDatabase 初始化数据库
-func (db *DBManager) initDatabase() {
+// getConnection 获取共享的连接池，调用方不需要关闭
+func (db *DBManager) getConnection() (*sql.DB, error) {
+	if db.conn == nil {
+		return nil, fmt.Errorf("数据库未打开: %s", db.dbPath)
+	}
+	return db.conn, nil
+}
+
+// withTx 在一个事务中执行 fn，fn 返回错误时回滚
+func (db *DBManager) withTx(fn func(tx *sql.Tx) error) error {
 	conn, err := db.getConnection()
 	if err != nil {
-		logError("初始化数据库失败: %v", err)
-		return
+		return err
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
-	defer conn.Close()
+	return tx.Commit()
+}
 
-	// 设置连接池参数
-	conn.SetMaxOpenConns(1)
-	conn.SetMaxIdleConns(1)
+// execer *sql.DB 与 *sql.Tx 共同的执行接口，使同一条更新语句可以单独执行或放在事务中
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
 
-	// 创建文件信息表
-	_, err = conn.Exec(`
-		CREATE TABLE IF NOT EXISTS files (
-			file_id TEXT PRIMARY KEY,
-			original_filename TEXT NOT NULL,
-			file_path TEXT NOT NULL,
-			file_size INTEGER NOT NULL,
-			total_chunks INTEGER NOT NULL,
-			total_lines INTEGER DEFAULT 0,
-			status TEXT NOT NULL,
-			created_time TEXT NOT NULL,
-			updated_time TEXT NOT NULL,
-			merged_path TEXT,
-			error_message TEXT,
-			retry INTEGER DEFAULT 0,
-			max_retry INTEGER DEFAULT 0
-		)
-	`)
-	if err != nil {
-		logError("创建files表失败: %v", err)
-		return
-	}
-
-	// 创建文件块表
-	_, err = conn.Exec(`
-		CREATE TABLE IF NOT EXISTS chunks (
-			chunk_id TEXT PRIMARY KEY,
-			file_id TEXT NOT NULL,
-			chunk_index INTEGER NOT NULL,
-			chunk_path TEXT NOT NULL,
-			chunk_size INTEGER NOT NULL,
-			status TEXT NOT NULL,
-			upload_file_id TEXT,
-			batch_id TEXT,
-			upload_time TEXT,
-			process_time TEXT,
-			batch_start_time TEXT,
-			error_message TEXT,
-			batch_task_info TEXT,
-			retry INTEGER DEFAULT 0,
-			FOREIGN KEY (file_id) REFERENCES files (file_id)
-		)
-	`)
-	if err != nil {
-		logError("创建chunks表失败: %v", err)
-		return
+// queryExecer 在 execer 基础上支持查询，用于迁移步骤
+type queryExecer interface {
+	execer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// tokenUsageColumns files 与 chunks 表中记录 token 用量的列
+var tokenUsageColumns = []string{"prompt_tokens", "completion_tokens", "reasoning_tokens"}
+
+// addColumnIfNotExists 列不存在时执行 ALTER TABLE ADD COLUMN
+func addColumnIfNotExists(conn queryExecer, table string, column string, definition string) error {
+	rows, err := conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 // CreateFile 创建文件记录
@@ -97,14 +142,13 @@ func (db *DBManager) CreateFile(fileInfo *FileInfo) error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		INSERT INTO files (
 			file_id, original_filename, file_path, file_size,
 			total_chunks, total_lines, status, created_time, updated_time,
-			merged_path, error_message, retry, max_retry
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			merged_path, error_message, retry, max_retry, cached_lines, rejected_lines, custom_id_key, compression, input_format, model
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		fileInfo.TaskID,
 		fileInfo.OriginalFilename,
@@ -119,25 +163,37 @@ func (db *DBManager) CreateFile(fileInfo *FileInfo) error {
 		fileInfo.ErrorMessage,
 		fileInfo.Retry,
 		fileInfo.MaxRetry,
+		fileInfo.CachedLines,
+		fileInfo.RejectedLines,
+		fileInfo.CustomIDKey,
+		fileInfo.Compression,
+		fileInfo.InputFormat,
+		fileInfo.Model,
 	)
 	return err
 }
 
-// GetFile 获取文件信息
-func (db *DBManager) GetFile(fileID string) (*FileInfo, error) {
-	conn, err := db.getConnection()
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
+// fileColumns files 表中 FileInfo 对应的列，顺序与 scanFile 一致
+const fileColumns = `file_id, original_filename, file_path, file_size,
+	total_chunks, total_lines, status, created_time, updated_time,
+	merged_path, error_message, retry, max_retry, cached_lines, rejected_lines, custom_id_key, compression, input_format, model,
+	prompt_tokens, completion_tokens, reasoning_tokens, estimated_input_tokens, estimated_output_tokens`
+
+// chunkColumns chunks 表中 FileChunk 对应的列，顺序与 scanChunk 一致
+const chunkColumns = `chunk_id, file_id, chunk_index, chunk_path, chunk_size,
+	status, upload_file_id, batch_id, upload_time, process_time,
+	batch_start_time, error_message, batch_task_info, retry,
+	prompt_tokens, completion_tokens, reasoning_tokens`
+
+// rowScanner *sql.Row 与 *sql.Rows 共有的 Scan
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+// scanFile 读取一行 fileColumns
+func scanFile(row rowScanner) (*FileInfo, error) {
 	var fileInfo FileInfo
-	err = conn.QueryRow(`
-		SELECT file_id, original_filename, file_path, file_size,
-		       total_chunks, total_lines, status, created_time, updated_time,
-		       merged_path, error_message, retry, max_retry
-		FROM files WHERE file_id = ?
-	`, fileID).Scan(
+	err := row.Scan(
 		&fileInfo.TaskID,
 		&fileInfo.OriginalFilename,
 		&fileInfo.FilePath,
@@ -151,124 +207,191 @@ func (db *DBManager) GetFile(fileID string) (*FileInfo, error) {
 		&fileInfo.ErrorMessage,
 		&fileInfo.Retry,
 		&fileInfo.MaxRetry,
+		&fileInfo.CachedLines,
+		&fileInfo.RejectedLines,
+		&fileInfo.CustomIDKey,
+		&fileInfo.Compression,
+		&fileInfo.InputFormat,
+		&fileInfo.Model,
+		&fileInfo.Usage.PromptTokens,
+		&fileInfo.Usage.CompletionTokens,
+		&fileInfo.Usage.ReasoningTokens,
+		&fileInfo.EstimatedUsage.PromptTokens,
+		&fileInfo.EstimatedUsage.CompletionTokens,
 	)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
 	if err != nil {
 		return nil, err
 	}
+	return &fileInfo, nil
+}
 
-	// 获取文件块
-	rows, err := conn.Query(`
-		SELECT chunk_id, file_id, chunk_index, chunk_path, chunk_size,
-		       status, upload_file_id, batch_id, upload_time, process_time,
-		       batch_start_time, error_message, batch_task_info, retry
-		FROM chunks WHERE file_id = ? ORDER BY chunk_index
-	`, fileID)
+// scanChunk 读取一行 chunkColumns
+func scanChunk(row rowScanner) (*FileChunk, error) {
+	var chunk FileChunk
+	var uploadFileID, batchID, uploadTime, processTime, batchStartTime, errorMessage, batchTaskInfoJSON sql.NullString
+
+	err := row.Scan(
+		&chunk.ChunkID,
+		&chunk.TaskID,
+		&chunk.ChunkIndex,
+		&chunk.ChunkPath,
+		&chunk.ChunkSize,
+		&chunk.Status,
+		&uploadFileID,
+		&batchID,
+		&uploadTime,
+		&processTime,
+		&batchStartTime,
+		&errorMessage,
+		&batchTaskInfoJSON,
+		&chunk.Retry,
+		&chunk.Usage.PromptTokens,
+		&chunk.Usage.CompletionTokens,
+		&chunk.Usage.ReasoningTokens,
+	)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	fileInfo.Chunks = []*FileChunk{}
-	for rows.Next() {
-		var chunk FileChunk
-		var uploadFileID, batchID, uploadTime, processTime, batchStartTime, errorMessage, batchTaskInfoJSON sql.NullString
-
-		err := rows.Scan(
-			&chunk.ChunkID,
-			&chunk.TaskID,
-			&chunk.ChunkIndex,
-			&chunk.ChunkPath,
-			&chunk.ChunkSize,
-			&chunk.Status,
-			&uploadFileID,
-			&batchID,
-			&uploadTime,
-			&processTime,
-			&batchStartTime,
-			&errorMessage,
-			&batchTaskInfoJSON,
-			&chunk.Retry,
-		)
-		if err != nil {
-			continue
-		}
+	if uploadFileID.Valid {
+		chunk.UploadFileID = &uploadFileID.String
+	}
+	if batchID.Valid {
+		chunk.BatchID = &batchID.String
+	}
+	if uploadTime.Valid {
+		chunk.UploadTime = &uploadTime.String
+	}
+	if processTime.Valid {
+		chunk.ProcessTime = &processTime.String
+	}
+	if batchStartTime.Valid {
+		chunk.BatchStartTime = &batchStartTime.String
+	}
+	if errorMessage.Valid {
+		chunk.ErrorMessage = &errorMessage.String
+	}
 
-		if uploadFileID.Valid {
-			chunk.UploadFileID = &uploadFileID.String
-		}
-		if batchID.Valid {
-			chunk.BatchID = &batchID.String
-		}
-		if uploadTime.Valid {
-			chunk.UploadTime = &uploadTime.String
-		}
-		if processTime.Valid {
-			chunk.ProcessTime = &processTime.String
-		}
-		if batchStartTime.Valid {
-			chunk.BatchStartTime = &batchStartTime.String
-		}
-		if errorMessage.Valid {
-			chunk.ErrorMessage = &errorMessage.String
+	// 解析 batch_task_info
+	if batchTaskInfoJSON.Valid && batchTaskInfoJSON.String != "" {
+		var batchTaskInfo BatchTaskInfo
+		if err := json.Unmarshal([]byte(batchTaskInfoJSON.String), &batchTaskInfo); err == nil {
+			chunk.BatchTaskInfo = &batchTaskInfo
 		}
+	}
+	return &chunk, nil
+}
 
-		// 解析 batch_task_info
-		if batchTaskInfoJSON.Valid && batchTaskInfoJSON.String != "" {
-			var batchTaskInfo BatchTaskInfo
-			if err := json.Unmarshal([]byte(batchTaskInfoJSON.String), &batchTaskInfo); err == nil {
-				chunk.BatchTaskInfo = &batchTaskInfo
-			}
-		}
+// GetFileHeader 只读取 files 表中的任务信息，不加载文件块（Chunks 为 nil），
+// 供只关心任务状态、重试轮次等字段的轮询与存在性检查使用；任务不存在时返回 nil
+func (db *DBManager) GetFileHeader(fileID string) (*FileInfo, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
 
-		fileInfo.Chunks = append(fileInfo.Chunks, &chunk)
+	fileInfo, err := scanFile(conn.QueryRow(`SELECT `+fileColumns+` FROM files WHERE file_id = ?`, fileID))
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
+	return fileInfo, err
+}
 
-	return &fileInfo, nil
+// GetFile 获取文件信息及其全部文件块
+func (db *DBManager) GetFile(fileID string) (*FileInfo, error) {
+	fileInfo, err := db.GetFileHeader(fileID)
+	if err != nil || fileInfo == nil {
+		return nil, err
+	}
+	if err := db.loadChunks([]*FileInfo{fileInfo}, `file_id = ?`, fileID); err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
 }
 
-// GetAllFiles 获取所有文件信息
-func (db *DBManager) GetAllFiles() ([]*FileInfo, error) {
+// loadChunks 用一次查询读取 where 条件（作用于 chunks 表）匹配的文件块，按 file_id 分配给 fileInfos
+func (db *DBManager) loadChunks(fileInfos []*FileInfo, where string, args ...interface{}) error {
 	conn, err := db.getConnection()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	byID := make(map[string]*FileInfo, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		fileInfo.Chunks = []*FileChunk{}
+		byID[fileInfo.TaskID] = fileInfo
 	}
-	defer conn.Close()
 
-	rows, err := conn.Query(`SELECT file_id FROM files ORDER BY created_time DESC`)
+	rows, err := conn.Query(`SELECT `+chunkColumns+` FROM chunks WHERE `+where+` ORDER BY file_id, chunk_index`, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var fileIDs []string
 	for rows.Next() {
-		var fileID string
-		if err := rows.Scan(&fileID); err == nil {
-			fileIDs = append(fileIDs, fileID)
+		chunk, err := scanChunk(rows)
+		if err != nil {
+			continue
+		}
+		if fileInfo, ok := byID[chunk.TaskID]; ok {
+			fileInfo.Chunks = append(fileInfo.Chunks, chunk)
 		}
 	}
+	return rows.Err()
+}
+
+// queryFiles 读取 where 条件（作用于 files 表）匹配的任务及其文件块，共两次查询
+func (db *DBManager) queryFiles(where string, orderBy string, args ...interface{}) ([]*FileInfo, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
 
+	rows, err := conn.Query(`SELECT `+fileColumns+` FROM files WHERE `+where+` ORDER BY `+orderBy, args...)
+	if err != nil {
+		return nil, err
+	}
 	var files []*FileInfo
-	for _, fileID := range fileIDs {
-		file, err := db.GetFile(fileID)
-		if err == nil && file != nil {
+	for rows.Next() {
+		if file, err := scanFile(rows); err == nil {
 			files = append(files, file)
 		}
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if len(files) == 0 {
+		return files, nil
+	}
+	fileIDs := make([]interface{}, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.TaskID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(fileIDs)), ",")
+	if err := db.loadChunks(files, `file_id IN (`+placeholders+`)`, fileIDs...); err != nil {
+		return nil, err
+	}
 	return files, nil
 }
 
+// GetAllFiles 获取所有文件信息
+func (db *DBManager) GetAllFiles() ([]*FileInfo, error) {
+	return db.queryFiles(`1 = 1`, `created_time DESC`)
+}
+
+// GetActiveFiles 获取分割完成与处理中的任务及其文件块
+func (db *DBManager) GetActiveFiles() ([]*FileInfo, error) {
+	return db.queryFiles(`status IN (?, ?)`, `created_time ASC`, string(FileStatusSplitCompleted), string(FileStatusProcessing))
+}
+
 // GetFileByFilename 通过文件名查询文件信息（返回第一个匹配的文件）
 func (db *DBManager) GetFileByFilename(filename string) (*FileInfo, error) {
 	conn, err := db.getConnection()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
 	var fileID string
 	err = conn.QueryRow(`
@@ -290,7 +413,6 @@ func (db *DBManager) UpdateFileStatus(fileID string, status FileStatus, errorMes
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		UPDATE files 
@@ -306,7 +428,6 @@ func (db *DBManager) UpdateFileMergedPath(fileID string, mergedPath string) erro
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		UPDATE files 
@@ -322,7 +443,6 @@ func (db *DBManager) UpdateFileRetry(fileID string, retry int) error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		UPDATE files 
@@ -338,7 +458,6 @@ func (db *DBManager) UpdateFileTotalChunks(fileID string, totalChunks int) error
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		UPDATE files 
@@ -354,7 +473,6 @@ func (db *DBManager) UpdateFileTotalLines(fileID string, totalLines int) error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		UPDATE files 
@@ -364,13 +482,47 @@ func (db *DBManager) UpdateFileTotalLines(fileID string, totalLines int) error {
 	return err
 }
 
+// UpdateFileSplitResult 分割完成时一次性写入块数、行数、缓存与跳过行数、预估用量，并将状态更新为分割完成
+func (db *DBManager) UpdateFileSplitResult(fileInfo *FileInfo) error {
+	conn, err := db.getConnection()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		UPDATE files
+		SET status = ?, error_message = NULL, total_chunks = ?, total_lines = ?, cached_lines = ?, rejected_lines = ?,
+		    estimated_input_tokens = ?, estimated_output_tokens = ?, updated_time = ?
+		WHERE file_id = ?
+	`, string(FileStatusSplitCompleted), fileInfo.TotalChunks, fileInfo.TotalLines, fileInfo.CachedLines, fileInfo.RejectedLines,
+		fileInfo.EstimatedUsage.PromptTokens, fileInfo.EstimatedUsage.CompletionTokens, time.Now().Format(time.RFC3339), fileInfo.TaskID)
+	return err
+}
+
+// UpdateFileUsage 将文件的 token 用量更新为各文件块用量之和
+func (db *DBManager) UpdateFileUsage(fileID string) error {
+	conn, err := db.getConnection()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		UPDATE files
+		SET prompt_tokens = (SELECT COALESCE(SUM(prompt_tokens), 0) FROM chunks WHERE file_id = ?),
+		    completion_tokens = (SELECT COALESCE(SUM(completion_tokens), 0) FROM chunks WHERE file_id = ?),
+		    reasoning_tokens = (SELECT COALESCE(SUM(reasoning_tokens), 0) FROM chunks WHERE file_id = ?),
+		    updated_time = ?
+		WHERE file_id = ?
+	`, fileID, fileID, fileID, time.Now().Format(time.RFC3339), fileID)
+	return err
+}
+
 // GetChunk 获取文件块
 func (db *DBManager) GetChunk(chunkID string) (*FileChunk, error) {
 	conn, err := db.getConnection()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
 	var chunk FileChunk
 	var uploadFileID, batchID, uploadTime, processTime, batchStartTime, errorMessage, batchTaskInfoJSON sql.NullString
@@ -378,7 +530,8 @@ func (db *DBManager) GetChunk(chunkID string) (*FileChunk, error) {
 	err = conn.QueryRow(`
 		SELECT chunk_id, file_id, chunk_index, chunk_path, chunk_size,
 		       status, upload_file_id, batch_id, upload_time, process_time,
-		       batch_start_time, error_message, batch_task_info, retry
+		       batch_start_time, error_message, batch_task_info, retry,
+		       prompt_tokens, completion_tokens, reasoning_tokens
 		FROM chunks WHERE chunk_id = ?
 	`, chunkID).Scan(
 		&chunk.ChunkID,
@@ -395,6 +548,9 @@ func (db *DBManager) GetChunk(chunkID string) (*FileChunk, error) {
 		&errorMessage,
 		&batchTaskInfoJSON,
 		&chunk.Retry,
+		&chunk.Usage.PromptTokens,
+		&chunk.Usage.CompletionTokens,
+		&chunk.Usage.ReasoningTokens,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -439,7 +595,6 @@ func (db *DBManager) AddChunk(chunk *FileChunk) error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	var batchTaskInfoJSON sql.NullString
 	if chunk.BatchTaskInfo != nil {
@@ -479,8 +634,11 @@ func (db *DBManager) UpdateChunkStatus(chunkID string, status ChunkStatus, error
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	return updateChunkStatus(conn, chunkID, status, errorMessage)
+}
 
+func updateChunkStatus(conn execer, chunkID string, status ChunkStatus, errorMessage *string) error {
+	var err error
 	now := time.Now().Format(time.RFC3339)
 	if status == ChunkStatusUploaded {
 		_, err = conn.Exec(`
@@ -510,10 +668,12 @@ func (db *DBManager) UpdateChunkUploadFileID(chunkID string, uploadFileID string
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	return updateChunkUploadFileID(conn, chunkID, uploadFileID)
+}
 
+func updateChunkUploadFileID(conn execer, chunkID string, uploadFileID string) error {
 	now := time.Now().Format(time.RFC3339)
-	_, err = conn.Exec(`
+	_, err := conn.Exec(`
 		UPDATE chunks 
 		SET upload_file_id = ?, upload_time = ?
 		WHERE chunk_id = ?
@@ -527,9 +687,11 @@ func (db *DBManager) UpdateChunkBatchID(chunkID string, batchID string) error {
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	return updateChunkBatchID(conn, chunkID, batchID)
+}
 
-	_, err = conn.Exec(`
+func updateChunkBatchID(conn execer, chunkID string, batchID string) error {
+	_, err := conn.Exec(`
 		UPDATE chunks 
 		SET batch_id = ?, batch_start_time = ?
 		WHERE chunk_id = ?
@@ -537,13 +699,33 @@ func (db *DBManager) UpdateChunkBatchID(chunkID string, batchID string) error {
 	return err
 }
 
+// MarkChunkUploaded 在一个事务中记录上传文件id并将文件块标记为已上传
+func (db *DBManager) MarkChunkUploaded(chunkID string, uploadFileID string) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		if err := updateChunkUploadFileID(tx, chunkID, uploadFileID); err != nil {
+			return err
+		}
+		return updateChunkStatus(tx, chunkID, ChunkStatusUploaded, nil)
+	})
+}
+
+// MarkChunkProcessing 在一个事务中记录batch任务id并将文件块标记为处理中，
+// 避免中途退出后文件块有batch_id但仍为已上传状态
+func (db *DBManager) MarkChunkProcessing(chunkID string, batchID string) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		if err := updateChunkBatchID(tx, chunkID, batchID); err != nil {
+			return err
+		}
+		return updateChunkStatus(tx, chunkID, ChunkStatusProcessing, nil)
+	})
+}
+
 // UpdateChunkBatchStartTime 更新文件块batch任务开始时间
 func (db *DBManager) UpdateChunkBatchStartTime(chunkID string, batchStartTime string) error {
 	conn, err := db.getConnection()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	_, err = conn.Exec(`
 		UPDATE chunks 
@@ -553,13 +735,27 @@ func (db *DBManager) UpdateChunkBatchStartTime(chunkID string, batchStartTime st
 	return err
 }
 
+// UpdateChunkUsage 更新文件块的 token 用量
+func (db *DBManager) UpdateChunkUsage(chunkID string, usage TokenUsage) error {
+	conn, err := db.getConnection()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		UPDATE chunks
+		SET prompt_tokens = ?, completion_tokens = ?, reasoning_tokens = ?
+		WHERE chunk_id = ?
+	`, usage.PromptTokens, usage.CompletionTokens, usage.ReasoningTokens, chunkID)
+	return err
+}
+
 // UpdateChunkBatchTaskInfo 更新文件块batch任务信息
 func (db *DBManager) UpdateChunkBatchTaskInfo(chunkID string, batchTaskInfo *BatchTaskInfo) error {
 	conn, err := db.getConnection()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	data, err := json.Marshal(batchTaskInfo)
 	if err != nil {
@@ -576,21 +772,19 @@ func (db *DBManager) UpdateChunkBatchTaskInfo(chunkID string, batchTaskInfo *Bat
 
 // DeleteFile 删除文件记录
 func (db *DBManager) DeleteFile(fileID string) error {
-	conn, err := db.getConnection()
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
+	return db.withTx(func(tx *sql.Tx) error {
+		// 先删除逐行记录和文件块
+		if _, err := tx.Exec(`DELETE FROM records WHERE task_id = ?`, fileID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM chunks WHERE file_id = ?`, fileID); err != nil {
+			return err
+		}
 
-	// 先删除文件块
-	_, err = conn.Exec(`DELETE FROM chunks WHERE file_id = ?`, fileID)
-	if err != nil {
+		// 再删除文件
+		_, err := tx.Exec(`DELETE FROM files WHERE file_id = ?`, fileID)
 		return err
-	}
-
-	// 再删除文件
-	_, err = conn.Exec(`DELETE FROM files WHERE file_id = ?`, fileID)
-	return err
+	})
 }
 
 // GetPendingFiles 获取需要自动执行的文件列表（状态为split_completed或processing的文件）
@@ -599,7 +793,6 @@ func (db *DBManager) GetPendingFiles() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
 	rows, err := conn.Query(`
 		SELECT file_id 
@@ -622,3 +815,287 @@ func (db *DBManager) GetPendingFiles() ([]string, error) {
 
 	return fileIDs, nil
 }
+
+// AddRecords 批量写入逐行状态（同一事务）
+func (db *DBManager) AddRecords(records []*Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return db.withTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`
+			INSERT OR REPLACE INTO records (
+				task_id, custom_id, retry, source_line, chunk_id, status, error_category, error_message, output_offset, updated_time
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		now := time.Now().Format(time.RFC3339)
+		for _, record := range records {
+			if _, err := stmt.Exec(record.TaskID, record.CustomID, record.Retry, record.SourceLine, record.ChunkID,
+				record.Status, record.ErrorCategory, record.ErrorMessage, record.OutputOffset, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddRetryRecords 为重试文件块中的记录新增一轮状态，原始行号沿用上一轮
+func (db *DBManager) AddRetryRecords(taskID string, chunkID string, retry int, customIDs []string) error {
+	if len(customIDs) == 0 {
+		return nil
+	}
+	return db.withTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`
+			INSERT OR REPLACE INTO records (task_id, custom_id, retry, source_line, chunk_id, status, updated_time)
+			SELECT task_id, custom_id, ?, source_line, ?, ?, ?
+			FROM records WHERE task_id = ? AND custom_id = ? AND retry = ?
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		now := time.Now().Format(time.RFC3339)
+		for _, customID := range customIDs {
+			if _, err := stmt.Exec(retry, chunkID, RecordStatusPending, now, taskID, customID, retry-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateChunkRecordsProcessed 文件块的批处理结束后，将其中等待中的记录标记为等待合并
+func (db *DBManager) UpdateChunkRecordsProcessed(chunkID string) error {
+	conn, err := db.getConnection()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		UPDATE records SET status = ?, updated_time = ?
+		WHERE chunk_id = ? AND status = ?
+	`, RecordStatusProcessed, time.Now().Format(time.RFC3339), chunkID, RecordStatusPending)
+	return err
+}
+
+// MergeChunkRecords 合并文件块时更新其中记录的状态：outputs 为成功记录在合并结果中的偏移，errorInfos 为失败记录的错误；
+// 两者都没有的记录标记为缺失。同一记录同时出现在两者中时按成功处理
+func (db *DBManager) MergeChunkRecords(chunkID string, outputs map[string]int64, errorInfos map[string]ErrorInfo) error {
+	return db.withTx(func(tx *sql.Tx) error {
+		now := time.Now().Format(time.RFC3339)
+
+		errorStmt, err := tx.Prepare(`
+			UPDATE records SET status = ?, error_category = ?, error_status_code = ?, error_code = ?, error_message = ?,
+				output_offset = NULL, updated_time = ?
+			WHERE chunk_id = ? AND custom_id = ?
+		`)
+		if err != nil {
+			return err
+		}
+		defer errorStmt.Close()
+		for customID, info := range errorInfos {
+			status := RecordStatusFailed
+			if !info.IsRetryable() {
+				status = RecordStatusTerminal
+			}
+			if _, err := errorStmt.Exec(status, info.Category, info.StatusCode, info.Code, info.Message, now, chunkID, customID); err != nil {
+				return err
+			}
+		}
+
+		outputStmt, err := tx.Prepare(`
+			UPDATE records SET status = ?, error_category = NULL, error_status_code = NULL, error_code = NULL, error_message = NULL,
+				output_offset = ?, updated_time = ?
+			WHERE chunk_id = ? AND custom_id = ?
+		`)
+		if err != nil {
+			return err
+		}
+		defer outputStmt.Close()
+		for customID, offset := range outputs {
+			if _, err := outputStmt.Exec(RecordStatusSuccess, offset, now, chunkID, customID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`
+			UPDATE records SET status = ?, error_category = 'missing', error_message = '未获取到该行的结果', updated_time = ?
+			WHERE chunk_id = ? AND status IN (?, ?)
+		`, RecordStatusMissing, now, chunkID, RecordStatusPending, RecordStatusProcessed)
+		return err
+	})
+}
+
+// GetRecordHistory 获取一条记录在各轮中的状态，按轮次排序
+func (db *DBManager) GetRecordHistory(taskID string, customID string) ([]*Record, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`SELECT `+recordColumns+` FROM records WHERE task_id = ? AND custom_id = ? ORDER BY retry`, taskID, customID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// GetUnfinishedRecords 获取最新一轮状态为失败、不可重试或缺失的记录，按原始行号排序，最多 limit 条
+func (db *DBManager) GetUnfinishedRecords(taskID string, limit int) ([]*Record, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		SELECT `+prefixColumns("r.", recordColumns)+`
+		FROM records r
+		JOIN (SELECT custom_id, MAX(retry) AS retry FROM records WHERE task_id = ? GROUP BY custom_id) latest
+		  ON r.custom_id = latest.custom_id AND r.retry = latest.retry
+		WHERE r.task_id = ? AND r.status IN (?, ?, ?)
+		ORDER BY r.source_line
+		LIMIT ?
+	`, taskID, taskID, RecordStatusFailed, RecordStatusTerminal, RecordStatusMissing, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// recordColumns records 表中 Record 对应的列，顺序与 scanRecords 一致
+const recordColumns = `task_id, custom_id, retry, source_line, chunk_id, status,
+	error_category, error_status_code, error_code, error_message, output_offset, updated_time`
+
+// prefixColumns 为逗号分隔的列名加上表别名前缀
+func prefixColumns(prefix string, columns string) string {
+	fields := strings.Split(columns, ",")
+	for i, field := range fields {
+		fields[i] = prefix + strings.TrimSpace(field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// scanRecords 读取 recordColumns 查询结果并关闭 rows
+func scanRecords(rows *sql.Rows) ([]*Record, error) {
+	defer rows.Close()
+
+	var records []*Record
+	for rows.Next() {
+		var record Record
+		var chunkID, errorCategory, errorCode, errorMessage sql.NullString
+		var errorStatusCode, outputOffset sql.NullInt64
+		if err := rows.Scan(&record.TaskID, &record.CustomID, &record.Retry, &record.SourceLine, &chunkID,
+			&record.Status, &errorCategory, &errorStatusCode, &errorCode, &errorMessage, &outputOffset, &record.UpdatedTime); err != nil {
+			return nil, err
+		}
+		if chunkID.Valid {
+			record.ChunkID = &chunkID.String
+		}
+		if errorCategory.Valid {
+			record.ErrorCategory = &errorCategory.String
+		}
+		if errorStatusCode.Valid {
+			statusCode := int(errorStatusCode.Int64)
+			record.ErrorStatusCode = &statusCode
+		}
+		if errorCode.Valid {
+			record.ErrorCode = &errorCode.String
+		}
+		if errorMessage.Valid {
+			record.ErrorMessage = &errorMessage.String
+		}
+		if outputOffset.Valid {
+			record.OutputOffset = &outputOffset.Int64
+		}
+		records = append(records, &record)
+	}
+	return records, rows.Err()
+}
+
+// GetChunkErrorRecords 查询文件块中失败、不可重试与缺失的记录（按原始行号排序，最多 limit 条）
+func (db *DBManager) GetChunkErrorRecords(chunkID string, limit int) ([]*Record, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		SELECT `+recordColumns+`
+		FROM records
+		WHERE chunk_id = ? AND status IN (?, ?, ?)
+		ORDER BY source_line
+		LIMIT ?
+	`, chunkID, RecordStatusFailed, RecordStatusTerminal, RecordStatusMissing, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// GetChunkUnfinishedRecords 查询文件块在第 retry 轮中失败、不可重试与缺失的全部记录（按原始行号排序），
+// 合并时据此写出失败、不可重试与缺失记录文件
+func (db *DBManager) GetChunkUnfinishedRecords(taskID string, chunkID string, retry int) ([]*Record, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		SELECT `+recordColumns+`
+		FROM records
+		WHERE task_id = ? AND retry = ? AND chunk_id = ? AND status IN (?, ?, ?)
+		ORDER BY source_line
+	`, taskID, retry, chunkID, RecordStatusFailed, RecordStatusTerminal, RecordStatusMissing)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// CountChunkRecords 统计文件块中的逐行记录数，旧任务的文件块没有逐行记录时为0
+func (db *DBManager) CountChunkRecords(chunkID string) (int, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = conn.QueryRow(`SELECT COUNT(*) FROM records WHERE chunk_id = ?`, chunkID).Scan(&count)
+	return count, err
+}
+
+// CountRecords 按状态统计任务各记录最新一轮的状态，旧任务没有逐行记录时返回空
+func (db *DBManager) CountRecords(taskID string) (map[string]int, error) {
+	conn, err := db.getConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(`
+		SELECT r.status, COUNT(*) FROM records r
+		JOIN (SELECT custom_id, MAX(retry) AS retry FROM records WHERE task_id = ? GROUP BY custom_id) latest
+		  ON r.custom_id = latest.custom_id AND r.retry = latest.retry
+		WHERE r.task_id = ?
+		GROUP BY r.status
+	`, taskID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
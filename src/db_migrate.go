@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migration 一个数据库结构变更步骤，版本号从1开始连续递增，已发布的步骤不能修改，只能追加新步骤
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations 按版本顺序排列的结构变更步骤。
+// 引入版本表之前创建的数据库没有版本记录，会从第1步开始执行，因此每一步都需要可以在已有该结构的库上重复执行
+var migrations = []migration{
+	{1, "创建 files 与 chunks 表", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS files (
+				file_id TEXT PRIMARY KEY,
+				original_filename TEXT NOT NULL,
+				file_path TEXT NOT NULL,
+				file_size INTEGER NOT NULL,
+				total_chunks INTEGER NOT NULL,
+				total_lines INTEGER DEFAULT 0,
+				status TEXT NOT NULL,
+				created_time TEXT NOT NULL,
+				updated_time TEXT NOT NULL,
+				merged_path TEXT,
+				error_message TEXT,
+				retry INTEGER DEFAULT 0,
+				max_retry INTEGER DEFAULT 0
+			)
+		`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS chunks (
+				chunk_id TEXT PRIMARY KEY,
+				file_id TEXT NOT NULL,
+				chunk_index INTEGER NOT NULL,
+				chunk_path TEXT NOT NULL,
+				chunk_size INTEGER NOT NULL,
+				status TEXT NOT NULL,
+				upload_file_id TEXT,
+				batch_id TEXT,
+				upload_time TEXT,
+				process_time TEXT,
+				batch_start_time TEXT,
+				error_message TEXT,
+				batch_task_info TEXT,
+				retry INTEGER DEFAULT 0,
+				FOREIGN KEY (file_id) REFERENCES files (file_id)
+			)
+		`)
+		return err
+	}},
+	{2, "files 增加 cached_lines（命中缓存行数）", func(tx *sql.Tx) error {
+		return addColumnIfNotExists(tx, "files", "cached_lines", "INTEGER DEFAULT 0")
+	}},
+	{3, "files 增加 custom_id_key", func(tx *sql.Tx) error {
+		return addColumnIfNotExists(tx, "files", "custom_id_key", "TEXT DEFAULT ''")
+	}},
+	{4, "files 增加 compression", func(tx *sql.Tx) error {
+		return addColumnIfNotExists(tx, "files", "compression", "TEXT DEFAULT ''")
+	}},
+	{5, "files 增加 input_format", func(tx *sql.Tx) error {
+		return addColumnIfNotExists(tx, "files", "input_format", "TEXT DEFAULT 'jsonl'")
+	}},
+	{6, "files 增加 model，files 与 chunks 增加 token 用量列", func(tx *sql.Tx) error {
+		if err := addColumnIfNotExists(tx, "files", "model", "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+		for _, table := range []string{"files", "chunks"} {
+			for _, column := range tokenUsageColumns {
+				if err := addColumnIfNotExists(tx, table, column, "INTEGER DEFAULT 0"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}},
+	{7, "files 增加分割时的预估用量列", func(tx *sql.Tx) error {
+		for _, column := range []string{"estimated_input_tokens", "estimated_output_tokens"} {
+			if err := addColumnIfNotExists(tx, "files", column, "INTEGER DEFAULT 0"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{8, "files 增加 rejected_lines（分割时跳过行数）", func(tx *sql.Tx) error {
+		return addColumnIfNotExists(tx, "files", "rejected_lines", "INTEGER DEFAULT 0")
+	}},
+	{9, "创建 records 表（逐行状态）", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS records (
+				task_id TEXT NOT NULL,
+				custom_id TEXT NOT NULL,
+				retry INTEGER NOT NULL,
+				source_line INTEGER NOT NULL,
+				chunk_id TEXT,
+				status TEXT NOT NULL,
+				error_category TEXT,
+				error_message TEXT,
+				output_offset INTEGER,
+				updated_time TEXT NOT NULL,
+				PRIMARY KEY (task_id, custom_id, retry)
+			)
+		`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_records_chunk ON records (chunk_id, custom_id)`)
+		return err
+	}},
+	{10, "records 增加 error_status_code 与 error_code（不可重试错误的原始状态码与错误码）", func(tx *sql.Tx) error {
+		if err := addColumnIfNotExists(tx, "records", "error_status_code", "INTEGER"); err != nil {
+			return err
+		}
+		return addColumnIfNotExists(tx, "records", "error_code", "TEXT")
+	}},
+}
+
+// latestSchemaVersion 当前程序支持的数据库版本
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// schemaVersion 读取数据库当前版本，没有版本表时为0
+func schemaVersion(conn queryExecer) (int, error) {
+	var version int
+	err := conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		exists, existsErr := tableExists(conn, "schema_version")
+		if existsErr == nil && !exists {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// tableExists 判断表是否存在
+func tableExists(conn queryExecer, table string) (bool, error) {
+	var count int
+	err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+// pendingMigrations 返回版本高于 version 的步骤
+func pendingMigrations(version int) []migration {
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// backupPath 迁移前备份文件的路径：<db>.v<版本>-<时间>.bak
+func (db *DBManager) backupPath(version int) string {
+	return fmt.Sprintf("%s.v%d-%s.bak", db.dbPath, version, time.Now().Format("20060102150405"))
+}
+
+// migrate 依次执行未应用的结构变更步骤。已有任务数据的库在迁移前先备份，
+// 每一步在独立事务中执行并写入版本表，中途失败时已完成的步骤保留，下次启动从失败的步骤继续
+func (db *DBManager) migrate() error {
+	conn, err := db.getConnection()
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_time TEXT NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("创建schema_version表失败: %v", err)
+	}
+
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return fmt.Errorf("读取数据库版本失败: %v", err)
+	}
+	if version > latestSchemaVersion() {
+		logInfo("警告: 数据库版本 %d 高于程序支持的版本 %d，请升级程序", version, latestSchemaVersion())
+		return nil
+	}
+	pending := pendingMigrations(version)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// 新建的空库不需要备份
+	hasFiles, err := tableExists(conn, "files")
+	if err != nil {
+		return err
+	}
+	if hasFiles {
+		backup := db.backupPath(version)
+		if _, err := conn.Exec(`VACUUM INTO ?`, backup); err != nil {
+			return fmt.Errorf("迁移前备份数据库失败: %v", err)
+		}
+		logInfo("数据库版本 %d → %d，迁移前已备份到: %s", version, latestSchemaVersion(), backup)
+	}
+
+	for _, m := range pending {
+		err := db.withTx(func(tx *sql.Tx) error {
+			// 其他进程可能已经执行了该步骤（事务开始即持有写锁，这里读到的是最新版本）
+			current, err := schemaVersion(tx)
+			if err != nil {
+				return err
+			}
+			if current >= m.version {
+				return nil
+			}
+			if err := m.apply(tx); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO schema_version (version, description, applied_time) VALUES (?, ?, ?)`,
+				m.version, m.description, time.Now().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("执行迁移 v%d（%s）失败: %v", m.version, m.description, err)
+		}
+		logInfo("已执行数据库迁移 v%d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+// RunDBMigrate 执行 -db-migrate：dryRun 时只列出待执行的步骤，不修改数据库
+func RunDBMigrate(dryRun bool) error {
+	if _, err := os.Stat(DB_PATH); os.IsNotExist(err) {
+		fmt.Printf("数据库不存在: %s，首次启动时将按最新版本 %d 创建\n", DB_PATH, latestSchemaVersion())
+		return nil
+	}
+
+	// dry-run 只读打开，不切换 WAL、不创建 schema_version 表
+	open := openDBManager
+	if dryRun {
+		open = openDBManagerReadOnly
+	}
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !dryRun {
+		if err := db.migrate(); err != nil {
+			return err
+		}
+	}
+
+	version, err := schemaVersion(db.conn)
+	if err != nil {
+		return fmt.Errorf("读取数据库版本失败: %v", err)
+	}
+	fmt.Printf("数据库: %s\n当前版本: %d | 程序版本: %d\n", DB_PATH, version, latestSchemaVersion())
+
+	pending := pendingMigrations(version)
+	if len(pending) == 0 {
+		fmt.Println("数据库已是最新版本")
+		return nil
+	}
+	fmt.Printf("待执行的迁移 %d 步（执行前会备份到 %s）：\n", len(pending), db.backupPath(version))
+	for _, m := range pending {
+		fmt.Printf("  v%d: %s\n", m.version, m.description)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legacyFilesTable 引入版本表之前的 files 表结构
+const legacyFilesTable = `
+	CREATE TABLE files (
+		file_id TEXT PRIMARY KEY,
+		original_filename TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		file_size INTEGER NOT NULL,
+		total_chunks INTEGER NOT NULL,
+		total_lines INTEGER DEFAULT 0,
+		status TEXT NOT NULL,
+		created_time TEXT NOT NULL,
+		updated_time TEXT NOT NULL,
+		merged_path TEXT,
+		error_message TEXT,
+		retry INTEGER DEFAULT 0,
+		max_retry INTEGER DEFAULT 0
+	)`
+
+const legacyChunksTable = `
+	CREATE TABLE chunks (
+		chunk_id TEXT PRIMARY KEY,
+		file_id TEXT NOT NULL,
+		chunk_index INTEGER NOT NULL,
+		chunk_path TEXT NOT NULL,
+		chunk_size INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		upload_file_id TEXT,
+		batch_id TEXT,
+		upload_time TEXT,
+		process_time TEXT,
+		batch_start_time TEXT,
+		error_message TEXT,
+		batch_task_info TEXT,
+		retry INTEGER DEFAULT 0
+	)`
+
+// tableColumns 列出表的所有列名
+func tableColumns(t *testing.T, conn *sql.DB, table string) map[string]bool {
+	t.Helper()
+	rows, err := conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns[name] = true
+	}
+	return columns
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements []string // 迁移前在库中执行的语句，为空表示新库
+		wantCached int
+		wantBackup bool
+	}{
+		{
+			name:       "新建的空库",
+			wantBackup: false,
+		},
+		{
+			name: "只有 files 与 chunks 的旧库",
+			statements: []string{
+				legacyFilesTable,
+				legacyChunksTable,
+				`INSERT INTO files (file_id, original_filename, file_path, file_size, total_chunks, total_lines, status, created_time, updated_time)
+					VALUES ('task1', 'input.jsonl', '/data/input.jsonl', 100, 1, 10, 'completed', '2024-01-01T00:00:00Z', '2024-01-01T00:00:00Z')`,
+				`INSERT INTO chunks (chunk_id, file_id, chunk_index, chunk_path, chunk_size, status)
+					VALUES ('task1_chunk_0', 'task1', 0, '/data/chunk_0.jsonl', 100, 'completed')`,
+			},
+			wantBackup: true,
+		},
+		{
+			name: "已手动加过部分列的旧库",
+			statements: []string{
+				legacyFilesTable,
+				legacyChunksTable,
+				`ALTER TABLE files ADD COLUMN cached_lines INTEGER DEFAULT 0`,
+				`ALTER TABLE files ADD COLUMN custom_id_key TEXT DEFAULT ''`,
+				`INSERT INTO files (file_id, original_filename, file_path, file_size, total_chunks, total_lines, status, created_time, updated_time, cached_lines)
+					VALUES ('task1', 'input.jsonl', '/data/input.jsonl', 100, 1, 10, 'completed', '2024-01-01T00:00:00Z', '2024-01-01T00:00:00Z', 3)`,
+			},
+			wantCached: 3,
+			wantBackup: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(old string) { DB_PATH = old }(DB_PATH)
+			dir := t.TempDir()
+			DB_PATH = filepath.Join(dir, "file_status.db")
+
+			legacy, err := sql.Open("sqlite", DB_PATH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, stmt := range tt.statements {
+				if _, err := legacy.Exec(stmt); err != nil {
+					t.Fatalf("创建旧库失败: %v", err)
+				}
+			}
+			// 确保空库也创建出数据库文件
+			if _, err := legacy.Exec(`PRAGMA user_version`); err != nil {
+				t.Fatal(err)
+			}
+			legacy.Close()
+			before, err := os.ReadFile(DB_PATH)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// dry-run 不能修改数据库文件
+			if err := RunDBMigrate(true); err != nil {
+				t.Fatalf("RunDBMigrate(dry-run): %v", err)
+			}
+			after, err := os.ReadFile(DB_PATH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(before) != string(after) {
+				t.Error("dry-run 修改了数据库文件")
+			}
+
+			db, err := openDBManager()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if version, err := schemaVersion(db.conn); err != nil || version != 0 {
+				t.Fatalf("迁移前版本 = %d, %v, want 0", version, err)
+			}
+			if err := db.migrate(); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+			// 重复执行不报错、不重复记录版本
+			if err := db.migrate(); err != nil {
+				t.Fatalf("再次 migrate: %v", err)
+			}
+
+			version, err := schemaVersion(db.conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != latestSchemaVersion() {
+				t.Errorf("迁移后版本 = %d, want %d", version, latestSchemaVersion())
+			}
+			var versionRows int
+			if err := db.conn.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&versionRows); err != nil {
+				t.Fatal(err)
+			}
+			if versionRows != len(migrations) {
+				t.Errorf("schema_version 行数 = %d, want %d", versionRows, len(migrations))
+			}
+
+			fileColumns := tableColumns(t, db.conn, "files")
+			for _, column := range append([]string{"cached_lines", "custom_id_key", "compression", "input_format", "model",
+				"estimated_input_tokens", "estimated_output_tokens", "rejected_lines"}, tokenUsageColumns...) {
+				if !fileColumns[column] {
+					t.Errorf("files 缺少列 %s", column)
+				}
+			}
+			chunkColumns := tableColumns(t, db.conn, "chunks")
+			for _, column := range tokenUsageColumns {
+				if !chunkColumns[column] {
+					t.Errorf("chunks 缺少列 %s", column)
+				}
+			}
+			if exists, err := tableExists(db.conn, "records"); err != nil || !exists {
+				t.Errorf("records 表不存在: %v", err)
+			}
+
+			// 旧数据保留，新增列取默认值
+			if len(tt.statements) > 0 {
+				var status, inputFormat string
+				var cachedLines int
+				err := db.conn.QueryRow(`SELECT status, input_format, cached_lines FROM files WHERE file_id = 'task1'`).
+					Scan(&status, &inputFormat, &cachedLines)
+				if err != nil {
+					t.Fatalf("读取旧任务失败: %v", err)
+				}
+				if status != "completed" || inputFormat != "jsonl" || cachedLines != tt.wantCached {
+					t.Errorf("旧任务 status=%q input_format=%q cached_lines=%d", status, inputFormat, cachedLines)
+				}
+			}
+
+			backups, err := filepath.Glob(DB_PATH + ".v0-*.bak")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasBackup := len(backups) > 0; hasBackup != tt.wantBackup {
+				t.Errorf("备份文件 %v, want backup=%v", backups, tt.wantBackup)
+			}
+		})
+	}
+}
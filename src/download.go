@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDownloadAttempts 单次下载中断后断点续传的最大尝试次数
+const maxDownloadAttempts = 5
+
+// downloadToFile 流式下载到 destPath：先写入 destPath.part，中断时用 HTTP Range 从已下载的位置续传，
+// 校验长度完整后再原子重命名为 destPath。失败时 destPath 保持不变，.part 保留供下次续传
+func downloadToFile(newRequest func() (*http.Request, error), destPath string) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	partPath := destPath + ".part"
+
+	var lastErr error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		done, err := downloadAttempt(newRequest, partPath)
+		if done {
+			return os.Rename(partPath, destPath)
+		}
+		lastErr = err
+		logInfo("下载中断（第%d次）: %s, %v", attempt, filepath.Base(destPath), err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	return fmt.Errorf("下载失败，已重试%d次: %v", maxDownloadAttempts, lastErr)
+}
+
+// downloadAttempt 发起一次（续传）请求，返回 .part 文件是否已完整
+func downloadAttempt(newRequest func() (*http.Request, error), partPath string) (bool, error) {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	// total 为完整文件的长度，-1 表示服务端未告知（chunked 传输）
+	var total int64 = -1
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// 服务端不支持 Range 时返回完整内容，从头重新写
+		flags |= os.O_TRUNC
+		offset = 0
+		total = resp.ContentLength
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			os.Remove(partPath)
+			return false, fmt.Errorf("Content-Range 不匹配: %s", resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 已下载的长度不小于文件长度：长度一致说明上次已下载完整，否则重新下载
+		_, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if ok && size == offset {
+			return true, nil
+		}
+		os.Remove(partPath)
+		return false, fmt.Errorf("续传位置超出文件长度: offset=%d", offset)
+	default:
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return false, fmt.Errorf("下载失败(%d): %s", resp.StatusCode, string(respBody))
+	}
+
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return false, err
+	}
+	written, copyErr := io.Copy(file, resp.Body)
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return false, copyErr
+	}
+
+	// 长度校验：截断的内容绝不能当作完整结果
+	if total >= 0 && offset+written != total {
+		return false, fmt.Errorf("下载长度不完整: %d/%d", offset+written, total)
+	}
+	// 服务端未告知长度且不是 chunked / gzip 传输（二者截断时读取会报错）时，连接提前关闭也会正常结束，
+	// 结果文件为 JSONL，只有以换行符结尾才认为完整，否则从已下载的位置续传
+	if total < 0 && !isChunked(resp) && !resp.Uncompressed {
+		complete, err := endsWithNewline(partPath)
+		if err != nil {
+			return false, err
+		}
+		if !complete {
+			return false, fmt.Errorf("下载长度未知且内容未以换行符结尾，可能被截断: %d 字节", offset+written)
+		}
+	}
+	return true, nil
+}
+
+// isChunked 响应是否使用 chunked 传输编码（有结束标记，截断时 Body 读取返回错误）
+func isChunked(resp *http.Response) bool {
+	for _, encoding := range resp.TransferEncoding {
+		if encoding == "chunked" {
+			return true
+		}
+	}
+	return false
+}
+
+// endsWithNewline 文件为空或最后一个字节为换行符
+func endsWithNewline(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() == 0 {
+		return true, nil
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] == '\n', nil
+}
+
+// parseContentRange 解析 "bytes start-end/size" 或 "bytes */size"
+func parseContentRange(header string) (start int64, size int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rangePart, sizePart, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseInt(sizePart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if rangePart == "*" {
+		return 0, size, true
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err = strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header    string
+		wantStart int64
+		wantSize  int64
+		wantOK    bool
+	}{
+		{"bytes 0-99/100", 0, 100, true},
+		{"bytes 50-99/100", 50, 100, true},
+		{"bytes */100", 0, 100, true},
+		{"bytes 50-99/*", 0, 0, false},
+		{"bytes 50/100", 0, 0, false},
+		{"bytes x-99/100", 0, 0, false},
+		{"items 0-99/100", 0, 0, false},
+		{"bytes 0-99", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, size, ok := parseContentRange(tt.header)
+		if start != tt.wantStart || size != tt.wantSize || ok != tt.wantOK {
+			t.Errorf("parseContentRange(%q) = (%d, %d, %v), want (%d, %d, %v)",
+				tt.header, start, size, ok, tt.wantStart, tt.wantSize, tt.wantOK)
+		}
+	}
+}
+
+// rangeServer 支持 Range 的下载服务，前 cutRequests 次请求只发送 cutBytes 字节后断开连接；
+// noLength 时不发送 Content-Length，以关闭连接结束响应；chunked 时以 chunked 编码发送
+type rangeServer struct {
+	body        string
+	ignoreRange bool
+	noLength    bool
+	chunked     bool
+	cutRequests int
+	cutBytes    int
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	rangeHeader := r.Header.Get("Range")
+	s.ranges = append(s.ranges, rangeHeader)
+	cut := len(s.ranges) <= s.cutRequests
+	s.mu.Unlock()
+
+	start := 0
+	if rangeHeader != "" && !s.ignoreRange {
+		start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+		if start >= len(s.body) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(s.body)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(s.body)-1, len(s.body)))
+	}
+	rest := s.body[start:]
+	if s.noLength {
+		if cut {
+			rest = rest[:s.cutBytes]
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+		status := "200 OK"
+		if start > 0 {
+			status = "206 Partial Content\r\nContent-Range: " + w.Header().Get("Content-Range")
+		}
+		fmt.Fprintf(buf, "HTTP/1.1 %s\r\nConnection: close\r\n\r\n%s", status, rest)
+		buf.Flush()
+		return
+	}
+	if !s.chunked {
+		w.Header().Set("Content-Length", strconv.Itoa(len(rest)))
+	}
+	if start > 0 {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if s.chunked {
+		w.(http.Flusher).Flush()
+	}
+	if cut {
+		w.Write([]byte(rest[:s.cutBytes]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write([]byte(rest))
+}
+
+func (s *rangeServer) requestRanges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func TestDownloadAttemptResume(t *testing.T) {
+	const body = "0123456789abcdefghijklmnopqrstuvwxyz"
+	const lines = "{\"custom_id\":\"1\"}\n{\"custom_id\":\"2\"}\n"
+	tests := []struct {
+		name       string
+		server     *rangeServer
+		part       string // 下载前已存在的 .part 内容
+		attempts   int
+		wantDone   bool
+		wantPart   string
+		wantRanges []string
+	}{
+		{
+			name:       "中断后从 .part 续传",
+			server:     &rangeServer{body: body, cutRequests: 1, cutBytes: 10},
+			attempts:   2,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{"", "bytes=10-"},
+		},
+		{
+			name:       "续传请求再次中断",
+			server:     &rangeServer{body: body, cutRequests: 2, cutBytes: 5},
+			attempts:   3,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{"", "bytes=5-", "bytes=10-"},
+		},
+		{
+			name:       "已有 .part 直接续传",
+			server:     &rangeServer{body: body},
+			part:       body[:20],
+			attempts:   1,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{"bytes=20-"},
+		},
+		{
+			name:       "服务端不支持 Range 时重新下载",
+			server:     &rangeServer{body: body, ignoreRange: true},
+			part:       body[:20],
+			attempts:   1,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{"bytes=20-"},
+		},
+		{
+			name:       ".part 已完整",
+			server:     &rangeServer{body: body},
+			part:       body,
+			attempts:   1,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{fmt.Sprintf("bytes=%d-", len(body))},
+		},
+		{
+			name:       "长度未知时以换行符结尾才算完成",
+			server:     &rangeServer{body: lines, noLength: true},
+			attempts:   1,
+			wantDone:   true,
+			wantPart:   lines,
+			wantRanges: []string{""},
+		},
+		{
+			name:       "长度未知且连接提前关闭时续传",
+			server:     &rangeServer{body: lines, noLength: true, cutRequests: 1, cutBytes: 10},
+			attempts:   2,
+			wantDone:   true,
+			wantPart:   lines,
+			wantRanges: []string{"", "bytes=10-"},
+		},
+		{
+			name:       "长度未知且未以换行符结尾不算完成",
+			server:     &rangeServer{body: lines, noLength: true, cutRequests: 1, cutBytes: 10},
+			attempts:   1,
+			wantDone:   false,
+			wantPart:   lines[:10],
+			wantRanges: []string{""},
+		},
+		{
+			name:       "chunked 传输不要求以换行符结尾",
+			server:     &rangeServer{body: body, chunked: true},
+			attempts:   1,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{""},
+		},
+		{
+			name:       "chunked 传输中断后续传",
+			server:     &rangeServer{body: body, chunked: true, cutRequests: 1, cutBytes: 10},
+			attempts:   2,
+			wantDone:   true,
+			wantPart:   body,
+			wantRanges: []string{"", "bytes=10-"},
+		},
+		{
+			name:       "截断的内容不算完成",
+			server:     &rangeServer{body: body, cutRequests: 1, cutBytes: 10},
+			attempts:   1,
+			wantDone:   false,
+			wantPart:   body[:10],
+			wantRanges: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.server)
+			defer server.Close()
+
+			partPath := filepath.Join(t.TempDir(), "output.jsonl.part")
+			if tt.part != "" {
+				if err := os.WriteFile(partPath, []byte(tt.part), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newRequest := func() (*http.Request, error) {
+				return http.NewRequest(http.MethodGet, server.URL, nil)
+			}
+
+			var done bool
+			var err error
+			for i := 0; i < tt.attempts && !done; i++ {
+				done, err = downloadAttempt(newRequest, partPath)
+			}
+			if done != tt.wantDone {
+				t.Fatalf("done = %v, want %v (err: %v)", done, tt.wantDone, err)
+			}
+			data, err := os.ReadFile(partPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.wantPart {
+				t.Errorf(".part = %q, want %q", data, tt.wantPart)
+			}
+			if got := tt.server.requestRanges(); strings.Join(got, ",") != strings.Join(tt.wantRanges, ",") {
+				t.Errorf("Range = %q, want %q", got, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestDownloadToFileResumesPartialBody(t *testing.T) {
+	body := strings.Repeat(`{"custom_id":"1","response":{}}`+"\n", 100)
+	server := httptest.NewServer(&rangeServer{body: body, cutRequests: 1, cutBytes: 1000})
+	defer server.Close()
+
+	destPath := filepath.Join(t.TempDir(), "result", "output.jsonl")
+	err := downloadToFile(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, server.URL, nil)
+	}, destPath)
+	if err != nil {
+		t.Fatalf("downloadToFile: %v", err)
+	}
+	data, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != body {
+		t.Errorf("下载内容长度 %d, want %d", len(data), len(body))
+	}
+	if _, err := os.Stat(destPath + ".part"); !os.IsNotExist(err) {
+		t.Errorf(".part 未重命名: %v", err)
+	}
+}
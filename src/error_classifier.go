@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrorCategory 错误分类
+type ErrorCategory string
+
+const (
+	ErrorCategoryRateLimit             ErrorCategory = "rate_limit"
+	ErrorCategoryQuotaExceeded         ErrorCategory = "quota_exceeded" // 账户额度或余额耗尽，重试也不会成功
+	ErrorCategoryServerError           ErrorCategory = "server_error"
+	ErrorCategoryTimeout               ErrorCategory = "timeout"
+	ErrorCategoryContentFilter         ErrorCategory = "content_filter"
+	ErrorCategoryContextLengthExceeded ErrorCategory = "context_length_exceeded"
+	ErrorCategoryInvalidRequest        ErrorCategory = "invalid_request"
+	ErrorCategoryUnknown               ErrorCategory = "unknown"
+)
+
+// AllErrorCategories 所有错误分类
+var AllErrorCategories = []ErrorCategory{
+	ErrorCategoryRateLimit,
+	ErrorCategoryQuotaExceeded,
+	ErrorCategoryServerError,
+	ErrorCategoryTimeout,
+	ErrorCategoryContentFilter,
+	ErrorCategoryContextLengthExceeded,
+	ErrorCategoryInvalidRequest,
+	ErrorCategoryUnknown,
+}
+
+// DefaultRetryErrorCategories 默认重试的错误分类（临时性错误）
+var DefaultRetryErrorCategories = []ErrorCategory{
+	ErrorCategoryRateLimit,
+	ErrorCategoryServerError,
+	ErrorCategoryTimeout,
+	ErrorCategoryUnknown,
+}
+
+// ErrorInfo 从error文件中解析出的错误信息
+type ErrorInfo struct {
+	Category   ErrorCategory `json:"category"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Code       string        `json:"code,omitempty"`
+	Message    string        `json:"message,omitempty"`
+}
+
+// IsRetryable 判断该错误是否需要重试（由配置 retry_error_categories 决定）
+func (e ErrorInfo) IsRetryable() bool {
+	return RETRY_ERROR_CATEGORIES[e.Category]
+}
+
+// parseErrorCategories 校验并转换配置中的错误分类
+func parseErrorCategories(names []string) (map[ErrorCategory]bool, error) {
+	categories := make(map[ErrorCategory]bool)
+	for _, name := range names {
+		valid := false
+		for _, c := range AllErrorCategories {
+			if ErrorCategory(name) == c {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("不支持的错误分类: %s", name)
+		}
+		categories[ErrorCategory(name)] = true
+	}
+	return categories, nil
+}
+
+// ClassifyErrorLine 解析error文件中的一行，得到结构化的错误分类
+// 兼容 {"error":{...}} 以及 {"response":{"status_code":...,"body":{"error":{...}}}} 两种格式
+func ClassifyErrorLine(record map[string]interface{}) ErrorInfo {
+	info := ErrorInfo{}
+
+	var errObj map[string]interface{}
+	if response, ok := record["response"].(map[string]interface{}); ok {
+		if statusCode, err := strconv.Atoi(stringField(response, "status_code")); err == nil {
+			info.StatusCode = statusCode
+		}
+		if body, ok := response["body"].(map[string]interface{}); ok {
+			if e, ok := body["error"].(map[string]interface{}); ok {
+				errObj = e
+			} else if _, ok := body["message"]; ok {
+				errObj = body
+			}
+		}
+	}
+	if e, ok := record["error"].(map[string]interface{}); ok {
+		errObj = e
+	}
+
+	errType := ""
+	if errObj != nil {
+		info.Code = stringField(errObj, "code")
+		errType = stringField(errObj, "type")
+		info.Message = stringField(errObj, "message")
+		if info.Code == "" {
+			info.Code = errType
+		}
+	}
+
+	info.Category = classifyError(info.StatusCode, info.Code, errType, info.Message)
+	return info
+}
+
+// stringField 读取字符串或数字字段
+func stringField(m map[string]interface{}, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return fmt.Sprintf("%d", int(v))
+	default:
+		return ""
+	}
+}
+
+// errorCodeCategories 服务商错误 code/type 对应的分类（OpenAI、Anthropic 以及 batch 级别的 expired/cancelled）。
+// 通用的 invalid_request_error 不在此列：Anthropic 的超长请求也使用该类型，需要先看错误文本
+var errorCodeCategories = map[string]ErrorCategory{
+	"context_length_exceeded":    ErrorCategoryContextLengthExceeded,
+	"string_above_max_length":    ErrorCategoryContextLengthExceeded,
+	"request_too_large":          ErrorCategoryContextLengthExceeded,
+	"content_filter":             ErrorCategoryContentFilter,
+	"content_policy_violation":   ErrorCategoryContentFilter,
+	"insufficient_quota":         ErrorCategoryQuotaExceeded,
+	"billing_hard_limit_reached": ErrorCategoryQuotaExceeded,
+	"billing_not_active":         ErrorCategoryQuotaExceeded,
+	"rate_limit_exceeded":        ErrorCategoryRateLimit,
+	"rate_limit_error":           ErrorCategoryRateLimit,
+	"requests":                   ErrorCategoryRateLimit,
+	"tokens":                     ErrorCategoryRateLimit,
+	"server_error":               ErrorCategoryServerError,
+	"api_error":                  ErrorCategoryServerError,
+	"overloaded_error":           ErrorCategoryServerError,
+	"internal_error":             ErrorCategoryServerError,
+	"service_unavailable":        ErrorCategoryServerError,
+	"timeout":                    ErrorCategoryTimeout,
+	"batch_expired":              ErrorCategoryTimeout,
+	"batch_cancelled":            ErrorCategoryUnknown,
+	"batch_canceled":             ErrorCategoryUnknown,
+	"invalid_api_key":            ErrorCategoryInvalidRequest,
+	"authentication_error":       ErrorCategoryInvalidRequest,
+	"permission_error":           ErrorCategoryInvalidRequest,
+	"not_found_error":            ErrorCategoryInvalidRequest,
+	"model_not_found":            ErrorCategoryInvalidRequest,
+	"invalid_prompt":             ErrorCategoryInvalidRequest,
+	"unsupported_value":          ErrorCategoryInvalidRequest,
+	"invalid_value":              ErrorCategoryInvalidRequest,
+	"missing_required_parameter": ErrorCategoryInvalidRequest,
+	"unknown_parameter":          ErrorCategoryInvalidRequest,
+	"invalid_type":               ErrorCategoryInvalidRequest,
+}
+
+// errorPhraseCategories 没有可识别的 code/type 时按错误文本中的完整短语分类，按顺序匹配。
+// 只使用完整短语，避免 "internal"、"safety" 这类常见单词把参数错误误判为服务端错误或内容审核
+var errorPhraseCategories = []struct {
+	category ErrorCategory
+	phrases  []string
+}{
+	{ErrorCategoryContextLengthExceeded, []string{"maximum context length", "context length exceeded", "context window", "prompt is too long", "too many tokens", "上下文长度", "超出最大长度"}},
+	{ErrorCategoryContentFilter, []string{"content management policy", "content policy", "flagged by the content filter", "内容审核不通过", "审核不通过", "包含敏感信息"}},
+	// OpenAI 的 insufficient_quota 也返回 429，需要在限流之前判断
+	{ErrorCategoryQuotaExceeded, []string{"exceeded your current quota", "credit balance is too low", "insufficient balance", "余额不足", "欠费", "额度已用完"}},
+	{ErrorCategoryRateLimit, []string{"rate limit", "too many requests", "请求过于频繁", "限流"}},
+	{ErrorCategoryTimeout, []string{"timed out", "请求超时"}},
+	{ErrorCategoryServerError, []string{"internal server error", "the server had an error", "server is overloaded", "service unavailable", "服务繁忙", "服务内部错误"}},
+}
+
+// classifyError 依次按服务商错误 code/type、错误文本中的完整短语、HTTP 状态码分类
+// （超长、内容审核通常也返回400，因此状态码最后判断）
+func classifyError(statusCode int, code string, errType string, message string) ErrorCategory {
+	for _, key := range []string{code, errType} {
+		if category, ok := errorCodeCategories[strings.ToLower(key)]; ok {
+			return category
+		}
+	}
+
+	text := strings.ToLower(message)
+	for _, entry := range errorPhraseCategories {
+		for _, phrase := range entry.phrases {
+			if strings.Contains(text, phrase) {
+				return entry.category
+			}
+		}
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorCategoryRateLimit
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return ErrorCategoryTimeout
+	case statusCode >= 500:
+		return ErrorCategoryServerError
+	case statusCode >= 400:
+		return ErrorCategoryInvalidRequest
+	}
+	switch strings.ToLower(errType) {
+	case "invalid_request_error", "invalid_request":
+		return ErrorCategoryInvalidRequest
+	}
+	return ErrorCategoryUnknown
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClassifyErrorLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want ErrorCategory
+	}{
+		{
+			name: "OpenAI 限流",
+			line: `{"id":"batch_req_1","custom_id":"1","response":{"status_code":429,"request_id":"r1","body":{"error":{"message":"Rate limit reached for gpt-4o-mini in organization org-x on tokens per min (TPM): Limit 200000, Used 199000, Requested 1500.","type":"tokens","param":null,"code":"rate_limit_exceeded"}}},"error":null}`,
+			want: ErrorCategoryRateLimit,
+		},
+		{
+			name: "OpenAI 额度耗尽同样返回429",
+			line: `{"id":"batch_req_2","custom_id":"2","response":{"status_code":429,"request_id":"r2","body":{"error":{"message":"You exceeded your current quota, please check your plan and billing details.","type":"insufficient_quota","param":null,"code":"insufficient_quota"}}},"error":null}`,
+			want: ErrorCategoryQuotaExceeded,
+		},
+		{
+			name: "OpenAI 超长",
+			line: `{"id":"batch_req_3","custom_id":"3","response":{"status_code":400,"request_id":"r3","body":{"error":{"message":"This model's maximum context length is 128000 tokens. However, your messages resulted in 130512 tokens. Please reduce the length of the messages.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}},"error":null}`,
+			want: ErrorCategoryContextLengthExceeded,
+		},
+		{
+			name: "OpenAI 内容审核",
+			line: `{"id":"batch_req_4","custom_id":"4","response":{"status_code":400,"request_id":"r4","body":{"error":{"message":"The response was filtered due to the prompt triggering Azure OpenAI's content management policy.","type":null,"param":"prompt","code":"content_filter"}}},"error":null}`,
+			want: ErrorCategoryContentFilter,
+		},
+		{
+			name: "OpenAI 服务端错误",
+			line: `{"id":"batch_req_5","custom_id":"5","response":{"status_code":500,"request_id":"r5","body":{"error":{"message":"The server had an error while processing your request. Sorry about that!","type":"server_error","param":null,"code":null}}},"error":null}`,
+			want: ErrorCategoryServerError,
+		},
+		{
+			name: "OpenAI batch 过期",
+			line: `{"id":"batch_req_6","custom_id":"6","response":null,"error":{"code":"batch_expired","message":"This request could not be executed before the completion window expired."}}`,
+			want: ErrorCategoryTimeout,
+		},
+		{
+			name: "OpenAI batch 取消",
+			line: `{"id":"batch_req_7","custom_id":"7","response":null,"error":{"code":"batch_cancelled","message":"This request was not executed because the batch was cancelled."}}`,
+			want: ErrorCategoryUnknown,
+		},
+		{
+			name: "参数错误中的 internal、safety 不影响分类",
+			line: `{"id":"batch_req_8","custom_id":"8","response":{"status_code":400,"request_id":"r8","body":{"error":{"message":"internal validation error: field safety_settings invalid","type":"invalid_request_error","param":"safety_settings","code":null}}},"error":null}`,
+			want: ErrorCategoryInvalidRequest,
+		},
+		{
+			name: "参数错误中的 sensitive 不影响分类",
+			line: `{"id":"batch_req_9","custom_id":"9","response":{"status_code":400,"request_id":"r9","body":{"error":{"message":"Invalid value for 'case_sensitive': expected a boolean.","type":"invalid_request_error","param":"case_sensitive","code":"invalid_value"}}},"error":null}`,
+			want: ErrorCategoryInvalidRequest,
+		},
+		{
+			name: "网关返回的纯文本5xx",
+			line: `{"custom_id":"10","response":{"status_code":502,"body":{"message":"<html><body>502 Bad Gateway</body></html>"}}}`,
+			want: ErrorCategoryServerError,
+		},
+		{
+			name: "中文错误文本",
+			line: `{"custom_id":"11","response":{"status_code":200,"body":{"error":{"code":"10013","message":"输入内容审核不通过，涉嫌违规"}}}}`,
+			want: ErrorCategoryContentFilter,
+		},
+		{
+			name: "没有任何错误信息",
+			line: `{"custom_id":"12","error":{}}`,
+			want: ErrorCategoryUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.line), &record); err != nil {
+				t.Fatal(err)
+			}
+			if got := ClassifyErrorLine(record).Category; got != tt.want {
+				t.Errorf("category = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyAnthropicResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		want   ErrorCategory
+	}{
+		{
+			name:   "超长请求使用 invalid_request_error",
+			result: `{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 215000 tokens > 200000 maximum"}}}`,
+			want:   ErrorCategoryContextLengthExceeded,
+		},
+		{
+			name:   "参数错误",
+			result: `{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"messages.0.content: Input should be a valid list"}}}`,
+			want:   ErrorCategoryInvalidRequest,
+		},
+		{
+			name:   "过载",
+			result: `{"type":"errored","error":{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}}`,
+			want:   ErrorCategoryServerError,
+		},
+		{
+			name:   "内部错误",
+			result: `{"type":"errored","error":{"type":"error","error":{"type":"api_error","message":"Internal server error"}}}`,
+			want:   ErrorCategoryServerError,
+		},
+		{
+			name:   "余额不足",
+			result: `{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low to access the Anthropic API. Please go to Plans & Billing to upgrade or purchase credits."}}}`,
+			want:   ErrorCategoryQuotaExceeded,
+		},
+		{
+			name:   "batch 过期",
+			result: `{"type":"expired"}`,
+			want:   ErrorCategoryTimeout,
+		},
+		{
+			name:   "batch 取消",
+			result: `{"type":"canceled"}`,
+			want:   ErrorCategoryUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.result), &result); err != nil {
+				t.Fatal(err)
+			}
+			line := convertAnthropicResult("msgbatch_1", map[string]interface{}{"custom_id": "1", "result": result})
+			// 与实际流程一致：转换后的行写入error文件后再读取
+			data, err := json.Marshal(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal(data, &record); err != nil {
+				t.Fatal(err)
+			}
+			if got := ClassifyErrorLine(record).Category; got != tt.want {
+				t.Errorf("category = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortRunBytes 外部排序时每个有序段在内存中累计的最大字节数（测试中调小以产生多个临时段）
+var sortRunBytes = 64 * 1024 * 1024
+
+// sortKey 结果行的排序键：数字 custom_id 按数值排序，非数字的排在最后并按字符串排序
+type sortKey struct {
+	num int64
+	str string
+}
+
+func (k sortKey) less(other sortKey) bool {
+	if k.num != other.num {
+		return k.num < other.num
+	}
+	return k.str < other.str
+}
+
+// unknownSortNum 无法确定顺序的行排在最后
+const unknownSortNum = math.MaxInt64
+
+// lineCustomID 取出结果行的 custom_id
+func lineCustomID(line string) string {
+	var record struct {
+		CustomID string `json:"custom_id"`
+	}
+	json.Unmarshal([]byte(line), &record)
+	return record.CustomID
+}
+
+// customIDSortKey 以数字 custom_id（原始行号）作为排序键
+func customIDSortKey(line string) sortKey {
+	customID := lineCustomID(line)
+	if num, err := strconv.ParseInt(customID, 10, 64); err == nil {
+		return sortKey{num: num, str: customID}
+	}
+	return sortKey{num: unknownSortNum, str: customID}
+}
+
+// sortItem 待排序的一行
+type sortItem struct {
+	key  sortKey
+	line string
+}
+
+// externalSortLines 将多个jsonl文件按 keyFunc 排序后写入 outputPath，返回写入的行数
+// 先按 sortRunBytes 切成若干内存有序段写入临时文件，再用最小堆多路归并，内存占用与文件大小无关
+func externalSortLines(inputPaths []string, outputPath string, keyFunc func(line string) sortKey) (int, error) {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(outputPath), ".sort_")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var runPaths []string
+	var items []sortItem
+	runSize := 0
+
+	flushRun := func() error {
+		if len(items) == 0 {
+			return nil
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].key.less(items[j].key)
+		})
+		runPath := filepath.Join(tmpDir, fmt.Sprintf("run_%d.jsonl", len(runPaths)))
+		if err := writeSortedLines(runPath, items); err != nil {
+			return err
+		}
+		runPaths = append(runPaths, runPath)
+		items = items[:0]
+		runSize = 0
+		return nil
+	}
+
+	for _, path := range inputPaths {
+		err := forEachLine(path, func(line string) error {
+			items = append(items, sortItem{key: keyFunc(line), line: line})
+			runSize += len(line)
+			if runSize >= sortRunBytes {
+				return flushRun()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := flushRun(); err != nil {
+		return 0, err
+	}
+
+	return mergeSortedRuns(runPaths, outputPath, keyFunc)
+}
+
+// writeSortedLines 将一个有序段写入临时文件
+func writeSortedLines(path string, items []sortItem) error {
+	writer, err := createLineWriter(path)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	for _, item := range items {
+		if err := writer.WriteLine(item.line); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// runCursor 归并时每个有序段的当前行
+type runCursor struct {
+	reader  *bufio.Reader
+	keyFunc func(line string) sortKey
+	item    sortItem
+	index   int // 有序段序号，键相同时保持输入顺序
+}
+
+// runHeap 按当前行排序键组织的最小堆
+type runHeap []*runCursor
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].item.key != h[j].item.key {
+		return h[i].item.key.less(h[j].item.key)
+	}
+	return h[i].index < h[j].index
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runCursor)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	cursor := old[n-1]
+	*h = old[:n-1]
+	return cursor
+}
+
+// advance 读取有序段的下一行，段读完时返回 false
+func (c *runCursor) advance() (bool, error) {
+	for {
+		line, err := c.reader.ReadString('\n')
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			c.item = sortItem{key: c.keyFunc(trimmed), line: trimmed}
+			return true, nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// mergeSortedRuns 多路归并有序段，写入 outputPath
+func mergeSortedRuns(runPaths []string, outputPath string, keyFunc func(line string) sortKey) (int, error) {
+	writer, err := createLineWriter(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	defer writer.Close()
+
+	h := &runHeap{}
+	for i, runPath := range runPaths {
+		file, err := os.Open(runPath)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+
+		cursor := &runCursor{reader: bufio.NewReader(file), keyFunc: keyFunc, index: i}
+		ok, err := cursor.advance()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			heap.Push(h, cursor)
+		}
+	}
+
+	for h.Len() > 0 {
+		cursor := (*h)[0]
+		if err := writer.WriteLine(cursor.item.line); err != nil {
+			return 0, err
+		}
+
+		ok, err := cursor.advance()
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return writer.count, nil
+}
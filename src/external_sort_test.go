@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExternalSortLinesAcrossRuns(t *testing.T) {
+	tests := []struct {
+		name     string
+		runBytes int
+		inputs   [][]string
+		want     []string
+	}{
+		{
+			name:     "单个内存段",
+			runBytes: 64 * 1024 * 1024,
+			inputs: [][]string{
+				{`{"custom_id":"3"}`, `{"custom_id":"1"}`},
+				{`{"custom_id":"2"}`},
+			},
+			want: []string{`{"custom_id":"1"}`, `{"custom_id":"2"}`, `{"custom_id":"3"}`},
+		},
+		{
+			name:     "每行一个临时段，按数值而非字符串排序",
+			runBytes: 1,
+			inputs: [][]string{
+				{`{"custom_id":"10"}`, `{"custom_id":"2"}`, `{"custom_id":"100"}`},
+				{`{"custom_id":"9"}`, `{"custom_id":"1"}`},
+			},
+			want: []string{`{"custom_id":"1"}`, `{"custom_id":"2"}`, `{"custom_id":"9"}`, `{"custom_id":"10"}`, `{"custom_id":"100"}`},
+		},
+		{
+			name:     "多行一个临时段，非数字排在最后",
+			runBytes: 40,
+			inputs: [][]string{
+				{`{"custom_id":"b"}`, `{"custom_id":"5"}`, `{"custom_id":"3"}`, `{"custom_id":"a"}`},
+				{`{"custom_id":"4"}`, `{}`, `{"custom_id":"1"}`},
+			},
+			want: []string{`{"custom_id":"1"}`, `{"custom_id":"3"}`, `{"custom_id":"4"}`, `{"custom_id":"5"}`, `{}`, `{"custom_id":"a"}`, `{"custom_id":"b"}`},
+		},
+		{
+			name:     "相同 custom_id 跨临时段保持输入顺序",
+			runBytes: 1,
+			inputs: [][]string{
+				{`{"custom_id":"2","v":"first"}`, `{"custom_id":"1"}`},
+				{`{"custom_id":"2","v":"second"}`, `{"custom_id":"2","v":"third"}`},
+			},
+			want: []string{`{"custom_id":"1"}`, `{"custom_id":"2","v":"first"}`, `{"custom_id":"2","v":"second"}`, `{"custom_id":"2","v":"third"}`},
+		},
+		{
+			name:     "空输入",
+			runBytes: 1,
+			inputs:   [][]string{{}},
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(old int) { sortRunBytes = old }(sortRunBytes)
+			sortRunBytes = tt.runBytes
+
+			dir := t.TempDir()
+			var inputPaths []string
+			for i, lines := range tt.inputs {
+				path := filepath.Join(dir, "input_"+string(rune('a'+i))+".jsonl")
+				content := strings.Join(lines, "\n")
+				if len(lines) > 0 {
+					content += "\n"
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				inputPaths = append(inputPaths, path)
+			}
+			// 不存在的输入文件视为空
+			inputPaths = append(inputPaths, filepath.Join(dir, "missing.jsonl"))
+
+			outputPath := filepath.Join(dir, "output.jsonl")
+			count, err := externalSortLines(inputPaths, outputPath, customIDSortKey)
+			if err != nil {
+				t.Fatalf("externalSortLines: %v", err)
+			}
+			if count != len(tt.want) {
+				t.Errorf("count = %d, want %d", count, len(tt.want))
+			}
+
+			var got []string
+			if err := forEachLine(outputPath, func(line string) error {
+				got = append(got, line)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("output =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".sort_") {
+					t.Errorf("临时目录未清理: %s", entry.Name())
+				}
+			}
+		})
+	}
+}
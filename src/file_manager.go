@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -18,13 +18,17 @@ import (
 // FileManager 文件管理器
 type FileManager struct {
 	dbManager *DBManager
+	cache     *ResultCache
 }
 
 // NewFileManager 创建文件管理器
 func NewFileManager(dbManager *DBManager) *FileManager {
-	return &FileManager{dbManager: dbManager}
+	return &FileManager{dbManager: dbManager, cache: NewResultCache(CACHE_DIR)}
 }
 
+// maxChunkBytes 单个分块文件的最大字节数（100M），与行数限制哪个先到按哪个分块
+const maxChunkBytes = 100 * 1024 * 1024
+
 // generatetaskID 生成文件ID
 func (fm *FileManager) generateTaskID(filename string) string {
 	timestamp := time.Now().Format(time.RFC3339)
@@ -47,21 +51,32 @@ func (fm *FileManager) writeChunk(taskID string, chunkIndex int, originalFilenam
 	chunkDir string, currentChunkLines []string, fileInfo *FileInfo, retry int) error {
 	chunkID := fm.generateChunkID(taskID, chunkIndex, retry)
 
+	// 分块文件的压缩方式由任务决定，与原始文件是否压缩无关
+	originalFilename = trimCompressionExt(originalFilename)
 	var chunkFilename string
 	if retry > 0 {
 		chunkFilename = fmt.Sprintf("retry%d_part%d.%s", retry, chunkIndex, originalFilename)
 	} else {
 		chunkFilename = fmt.Sprintf("part%d.%s", chunkIndex, originalFilename)
 	}
-	chunkPath := filepath.Join(chunkDir, chunkFilename)
-
-	// 写入块文件（保留换行符）
-	chunkData := strings.Join(currentChunkLines, "\n")
+	chunkPath := withCompressionExt(filepath.Join(chunkDir, chunkFilename), fileInfo.Compression)
 
-	err := os.WriteFile(chunkPath, []byte(chunkData), 0644)
+	// 写入块文件（每行一条请求）
+	writer, err := createLineWriter(chunkPath)
 	if err != nil {
 		return err
 	}
+	chunkSize := 0
+	for _, line := range currentChunkLines {
+		if err := writer.WriteLine(line); err != nil {
+			writer.Close()
+			return err
+		}
+		chunkSize += len(line) + 1
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
 
 	// 创建文件块信息
 	chunk := &FileChunk{
@@ -69,7 +84,7 @@ func (fm *FileManager) writeChunk(taskID string, chunkIndex int, originalFilenam
 		TaskID:     taskID,
 		ChunkIndex: chunkIndex,
 		ChunkPath:  chunkPath,
-		ChunkSize:  len([]byte(chunkData)),
+		ChunkSize:  chunkSize,
 		Status:     ChunkStatusPending,
 		Retry:      retry,
 	}
@@ -82,86 +97,161 @@ func (fm *FileManager) writeChunk(taskID string, chunkIndex int, originalFilenam
 	fileInfo.Chunks = append(fileInfo.Chunks, chunk)
 	return nil
 }
-func ValidateMessage(message interface{}) error {
-	//将interface{}类型断言为map[string]interface{}（JSON对象解析后的标准类型）
-	msgMap, ok := message.(map[string]interface{})
-	if !ok {
-		return errors.New("message格式错误：非有效的JSON对象类型")
-	}
 
-	//校验必选字段role - 存在性 + 字符串类型
-	roleVal, hasRole := msgMap["role"]
-	if !hasRole {
-		return errors.New("message格式错误：缺少必选字段role")
+// batchRequest 由一条输入记录构建出的batch请求
+type batchRequest struct {
+	CustomID string
+	Messages []interface{}
+	Line     map[string]interface{}
+	JSON     []byte // Line 序列化后的请求行（不含换行符）
+}
+
+// buildBatchRequest 按分割规则由一条输入记录构建batch请求，返回错误时该行不提交（SplitFile 写入 rejected_input.jsonl）：
+// 无法构建 messages、custom_id 无效、messages 未通过 -validate 的校验（如 content 为空）、请求超过单行上限
+func buildBatchRequest(record map[string]interface{}, customIDKey string, lineNumber int) (*batchRequest, error) {
+	messages, err := buildMessages(record)
+	if err != nil {
+		return nil, err
 	}
-	if _, ok := roleVal.(string); !ok {
-		return errors.New("message格式错误：role字段必须为字符串类型")
+	customID, err := recordCustomID(record, customIDKey, lineNumber)
+	if err != nil {
+		return nil, err
 	}
-
-	//校验content字段 - 存在性 + 字符串/数组类型（JSON数组解析后为[]interface{}）
-	contentVal, hasContent := msgMap["content"]
-	if !hasContent {
-		return errors.New("message格式错误：缺少字段content")
+	if _, err := validateRequestMessages(messages); err != nil {
+		return nil, err
+	}
+	line := buildRequestLine(customID, messages)
+	data, err := json.Marshal(line)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
 	}
-	// 判断content是否为字符串 或 数组（[]interface{}）
-	switch contentVal.(type) {
-	case string:
-		// 字符串类型，合法
-	case []interface{}:
-		// 数组类型，合法（JSON数组解析后统一为[]interface{}）
-	default:
-		return errors.New("message格式错误：content字段必须为字符串或数组类型")
+	if err := checkRequestLineSize(len(data) + 1); err != nil {
+		return nil, err
 	}
+	return &batchRequest{CustomID: customID, Messages: messages, Line: line, JSON: data}, nil
+}
 
-	// 所有校验通过
-	return nil
+// errStopRecords 已遍历到指定条数，提前结束读取输入文件
+var errStopRecords = errors.New("stop")
+
+// forEachBatchRequest 按 SplitFile 的规则遍历输入文件中会被提交（或命中缓存）的记录：buildBatchRequest 失败的行跳过，
+// 依次回调请求、记录、行号与原始文本；limit > 0 时最多回调 limit 条（分割时为 test_lines，分割后为任务的 TotalLines）
+func forEachBatchRequest(path string, format string, customIDKey string, limit int,
+	fn func(request *batchRequest, record map[string]interface{}, lineNumber int, raw string) error) error {
+	count := 0
+	err := forEachInputRecord(path, format, func(record map[string]interface{}, lineNumber int, raw string) error {
+		if limit > 0 && count >= limit {
+			return errStopRecords
+		}
+		request, err := buildBatchRequest(record, customIDKey, lineNumber)
+		if err != nil {
+			return nil
+		}
+		count++
+		return fn(request, record, lineNumber, raw)
+	})
+	if err == errStopRecords {
+		return nil
+	}
+	return err
 }
 
-func CheckFileFormat(file *os.File) error {
-	if file == nil {
-		return fmt.Errorf("file si nil")
+// buildMessages 构建一条输入记录的messages：配置了 prompt_template 时由模板渲染，否则取 messages_key 字段
+func buildMessages(record map[string]interface{}) ([]interface{}, error) {
+	if PromptTmpl != nil {
+		return PromptTmpl.Render(record)
 	}
-	scanner := bufio.NewScanner(file)
-	currentLine := 0
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
+	messages, ok := record[ModelConf.MessagesKey].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s(%v) is wrong", ModelConf.MessagesKey, record[ModelConf.MessagesKey])
+	}
+	return messages, nil
+}
 
-		// 解析JSON
-		var originJSON map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &originJSON); err != nil {
-			return fmt.Errorf("err in line %d: %s", currentLine, err.Error())
+// buildRequestLine 根据 provider 构建一行batch请求
+// OpenAI 兼容接口为 {"custom_id","method","url","body"}，Anthropic 为 {"custom_id","params"}
+func buildRequestLine(customID string, messages []interface{}) map[string]interface{} {
+	if ModelConf.Provider == ProviderAnthropic {
+		return map[string]interface{}{
+			"custom_id": customID,
+			"params":    buildAnthropicParams(messages),
 		}
-		messages, ok := originJSON[ModelConf.MessagesKey].([]interface{})
-		if !ok {
-			return fmt.Errorf("err in line %d: %s(%s) is wrong", currentLine, ModelConf.MessagesKey, originJSON[ModelConf.MessagesKey])
-		}
-		for _, message := range messages {
-			if err := ValidateMessage(message); err != nil {
-				return fmt.Errorf("err in line %d, ")
+	}
+
+	body := map[string]interface{}{
+		"model":      ModelConf.Domain,
+		"messages":   messages,
+		"max_tokens": ModelConf.MaxTokens,
+	}
+	if ModelConf.Temperature != nil {
+		body["temperature"] = *ModelConf.Temperature
+	}
+	if ModelConf.TopP != nil {
+		body["top_p"] = *ModelConf.TopP
+	}
+	if ModelConf.EnableThinking != nil {
+		body["enable_thinking"] = *ModelConf.EnableThinking
+	}
+	if ModelConf.ExtraBody != nil && len(ModelConf.ExtraBody) > 0 {
+		body["extra_body"] = ModelConf.ExtraBody
+	}
+	return map[string]interface{}{
+		"custom_id": customID,
+		"method":    "POST",
+		"url":       "/v1/chat/completions",
+		"body":      body,
+	}
+}
+
+// buildAnthropicParams 构建 Anthropic Messages 请求参数
+// system 角色的消息合并到顶层 system 字段，extra_body 中的字段（如 thinking）直接合并到参数中
+func buildAnthropicParams(messages []interface{}) map[string]interface{} {
+	var systemParts []string
+	chatMessages := []interface{}{}
+	for _, message := range messages {
+		msgMap, ok := message.(map[string]interface{})
+		if ok && msgMap["role"] == "system" {
+			if content, ok := msgMap["content"].(string); ok {
+				systemParts = append(systemParts, content)
+				continue
 			}
 		}
-
+		chatMessages = append(chatMessages, message)
 	}
-	return nil
 
+	params := map[string]interface{}{
+		"model":      ModelConf.Domain,
+		"messages":   chatMessages,
+		"max_tokens": ModelConf.MaxTokens,
+	}
+	if len(systemParts) > 0 {
+		params["system"] = strings.Join(systemParts, "\n\n")
+	}
+	if ModelConf.Temperature != nil {
+		params["temperature"] = *ModelConf.Temperature
+	}
+	if ModelConf.TopP != nil {
+		params["top_p"] = *ModelConf.TopP
+	}
+	for k, v := range ModelConf.ExtraBody {
+		params[k] = v
+	}
+	return params
 }
 
-// SplitFile 分割文件（按行数）
-func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskID string, linesPerChunk int) (*FileInfo, error) {
-	file, err := os.Open(filePath)
+// SplitFile 分割文件（按行数），inputFormat 为空时按扩展名判断输入格式
+func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskID string, inputFormat string, linesPerChunk int) (*FileInfo, error) {
+	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("文件不存在: %s", filePath)
 	}
-	defer file.Close()
+	fileSize := fileInfo.Size()
 
-	fileInfo, err := file.Stat()
+	// 按 -input-format 或扩展名选择输入格式（jsonl、csv、parquet）
+	inputFormat, err = detectInputFormat(filePath, inputFormat)
 	if err != nil {
 		return nil, err
 	}
-	fileSize := fileInfo.Size()
 
 	// 创建文件信息
 	fileInfoObj := &FileInfo{
@@ -177,6 +267,17 @@ func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskI
 		Chunks:           []*FileChunk{},
 		Retry:            0,
 		MaxRetry:         MAX_RETRY_COUNT, // 在分割文件时写入最大重试次数
+		CustomIDKey:      CUSTOM_ID_KEY,
+		Compression:      COMPRESS_OUTPUT,
+		InputFormat:      inputFormat,
+		Model:            ModelConf.Domain,
+	}
+
+	// 使用自定义 custom_id 时，先检查会被提交的记录中是否有重复，重复时不创建任务；字段缺失的行分割时跳过
+	if fileInfoObj.CustomIDKey != "" {
+		if err := CheckDuplicateCustomIDs(filePath, inputFormat, fileInfoObj.CustomIDKey); err != nil {
+			return nil, fmt.Errorf("custom_id 检查失败: %v", err)
+		}
 	}
 
 	// 保存文件信息到数据库
@@ -190,16 +291,10 @@ func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskI
 		return nil, err
 	}
 
-	scanner := bufio.NewScanner(file)
-	// 设置更大的缓冲区以支持超长行（默认64KB，设置为10MB）
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 10*1024*1024) // 最大10MB的缓冲区
-
 	chunkIndex := 0
 	currentChunkLines := []string{}
 	totalLines := 0
-	currentChunkSize := 0                  // 当前chunk的累计大小（字节数）
-	const maxChunkSize = 100 * 1024 * 1024 // 100M = 104857600 字节
+	currentChunkSize := 0 // 当前chunk的累计大小（字节数）
 
 	// 检查文件是否为空
 	logInfo("文件大小: %d 字节", fileSize)
@@ -208,63 +303,98 @@ func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskI
 		return fileInfoObj, nil
 	}
 
+	// .gz / .zst 输入边读边解压
+	reader, err := openInputReader(filePath, inputFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var estimate TokenUsage
 	lineCount := 0
-	for scanner.Scan() {
-		lineCount++
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
+	cachedWriter := newCachedResultWriter(fm.cache, fileInfoObj)
+	defer cachedWriter.Close()
 
-		// 解析JSON
-		var originJSON map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &originJSON); err != nil {
-			logInfo("err in line %d: %s", chunkIndex, err.Error())
-			continue
+	// 跳过的行写入 merged/<task_id>/rejected_input.jsonl，便于排查丢失的数据
+	rejectedWriter := newRejectedInputWriter(taskID)
+	defer rejectedWriter.Close()
+	reject := func(reason string) {
+		logInfo("跳过第%d行: %s", lineCount, reason)
+		if err := rejectedWriter.Write(lineCount, reason, reader.Raw()); err != nil {
+			logError("写入跳过行失败: %v", err)
 		}
+	}
 
-		// 构建新行
-		messages, ok := originJSON[ModelConf.MessagesKey].([]interface{})
-		if !ok {
-			continue
-		}
-		body := map[string]interface{}{
-			"model":      ModelConf.Domain,
-			"messages":   messages,
-			"max_tokens": ModelConf.MaxTokens,
-		}
-		if ModelConf.Temperature != nil {
-			body["temperature"] = *ModelConf.Temperature
+	// 当前文件块中各行（及命中缓存的行）的逐行状态，写入文件块后一起保存
+	var chunkRecords []*Record
+	saveRecords := func(chunkID string) {
+		for _, record := range chunkRecords {
+			if record.Status == RecordStatusPending {
+				record.ChunkID = &chunkID
+			}
 		}
-		if ModelConf.TopP != nil {
-			body["top_p"] = *ModelConf.TopP
+		if err := fm.dbManager.AddRecords(chunkRecords); err != nil {
+			logError("保存逐行状态失败: %v", err)
 		}
-		if ModelConf.EnableThinking != nil {
-			body["enable_thinking"] = *ModelConf.EnableThinking
+		chunkRecords = nil
+	}
+
+	for {
+		originJSON, lineNumber, err := reader.Next()
+		if err == io.EOF {
+			break
 		}
-		if ModelConf.ExtraBody != nil && len(ModelConf.ExtraBody) > 0 {
-			body["extra_body"] = ModelConf.ExtraBody
+		lineCount = lineNumber
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			reject(fmt.Sprintf("解析失败: %v", recordErr.Err))
+			continue
 		}
-		newline := map[string]interface{}{
-			"custom_id": fmt.Sprintf("%d", lineCount),
-			"method":    "POST",
-			"url":       "/v1/chat/completions",
-			"body":      body,
+		if err != nil {
+			logError("读取文件时发生错误: %v", err)
+			return nil, fmt.Errorf("读取文件错误: %v", err)
 		}
 
-		newlineJSON, err := json.Marshal(newline)
+		// 构建新行
+		request, err := buildBatchRequest(originJSON, fileInfoObj.CustomIDKey, lineCount)
 		if err != nil {
+			reject(err.Error())
 			continue
 		}
-
+		customID, messages, newline, newlineJSON := request.CustomID, request.Messages, request.Line, request.JSON
 		// 计算当前行的字节大小（包括换行符）
 		lineSize := len(newlineJSON) + 1 // +1 是换行符 \n
+
+		// 命中结果缓存的请求不再提交，缓存结果在合并时写回output
+		if CACHE_ENABLED {
+			hit, err := cachedWriter.TryWrite(newline)
+			if err != nil {
+				logError("写入缓存结果失败: %v", err)
+			} else if hit {
+				chunkRecords = append(chunkRecords, &Record{TaskID: taskID, CustomID: customID, SourceLine: lineCount, Status: RecordStatusCached})
+				totalLines++
+				if TEST_LINES > 0 && totalLines >= TEST_LINES {
+					break
+				}
+				continue
+			}
+		}
+
+		// 估算用量，超出预算时不创建任务
+		estimate.Add(TokenUsage{PromptTokens: int64(estimateMessagesTokens(messages)), CompletionTokens: int64(ModelConf.MaxTokens)})
+		if err := checkBudget(fileInfoObj.Model, estimate); err != nil {
+			cachedWriter.Close()
+			rejectedWriter.Close()
+			fm.discardTask(taskID)
+			return nil, fmt.Errorf("第%d行处超出预算，已取消任务: %v", lineCount, err)
+		}
+
 		newChunkSize := currentChunkSize + lineSize
 		newChunkLineCount := len(currentChunkLines) + 1
 
 		// 如果当前行大小+以前的大小超过100M，或者行数达到限制，将以前的写入文件，本次继续累计
 		// 哪个先到就按那个来
-		if (newChunkSize > maxChunkSize || newChunkLineCount > linesPerChunk) && len(currentChunkLines) > 0 {
+		if (newChunkSize > maxChunkBytes || newChunkLineCount > linesPerChunk) && len(currentChunkLines) > 0 {
 			if err := fm.writeChunk(taskID, chunkIndex, originalFilename, chunkDir, currentChunkLines, fileInfoObj, fileInfoObj.Retry); err != nil {
 				errorMsg := err.Error()
 				fm.dbManager.UpdateFileStatus(taskID, FileStatusFailed, &errorMsg)
@@ -272,12 +402,14 @@ func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskI
 				fileInfoObj.ErrorMessage = &errorMsg
 				return nil, err
 			}
+			saveRecords(fm.generateChunkID(taskID, chunkIndex, fileInfoObj.Retry))
 			chunkIndex++
 			currentChunkLines = []string{}
 			currentChunkSize = 0
 		}
 
 		currentChunkLines = append(currentChunkLines, string(newlineJSON))
+		chunkRecords = append(chunkRecords, &Record{TaskID: taskID, CustomID: customID, SourceLine: lineCount, Status: RecordStatusPending})
 		currentChunkSize += lineSize
 		totalLines++
 
@@ -286,13 +418,19 @@ func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskI
 		}
 	}
 
-	// 检查是否有读取错误
-	if err := scanner.Err(); err != nil {
-		logError("读取文件时发生错误: %v", err)
-		return nil, fmt.Errorf("读取文件错误: %v", err)
+	if err := cachedWriter.Close(); err != nil {
+		logError("写入缓存结果失败: %v", err)
+		return nil, fmt.Errorf("写入缓存结果失败: %v", err)
+	}
+	if err := rejectedWriter.Close(); err != nil {
+		logError("写入跳过行失败: %v", err)
 	}
 
-	logInfo("文件读取完成，共读取 %d 行，有效处理 %d 行", lineCount, totalLines)
+	logInfo("文件读取完成，共读取 %d 行，有效处理 %d 行，命中缓存 %d 行，跳过 %d 行，预估输入token %d，最大输出token %d",
+		lineCount, totalLines, cachedWriter.count, rejectedWriter.count, estimate.PromptTokens, estimate.CompletionTokens)
+	if rejectedWriter.count > 0 {
+		logInfo("跳过的行已写入: %s", rejectedWriter.path)
+	}
 
 	// 处理剩余的行
 	if len(currentChunkLines) > 0 {
@@ -303,51 +441,79 @@ func (fm *FileManager) SplitFile(filePath string, originalFilename string, taskI
 			fileInfoObj.ErrorMessage = &errorMsg
 			return nil, err
 		}
+		saveRecords(fm.generateChunkID(taskID, chunkIndex, fileInfoObj.Retry))
 		chunkIndex++
 	}
+	// 最后一个文件块之后命中缓存的行
+	if len(chunkRecords) > 0 {
+		saveRecords("")
+	}
 
 	// 更新总块数和总行数
 	fileInfoObj.TotalChunks = chunkIndex
 	fileInfoObj.TotalLines = totalLines
+	fileInfoObj.CachedLines = cachedWriter.count
+	fileInfoObj.RejectedLines = rejectedWriter.count
+	fileInfoObj.EstimatedUsage = estimate
 
-	// 更新状态为分割完成
-	fm.dbManager.UpdateFileStatus(taskID, FileStatusSplitCompleted, nil)
-	fm.dbManager.UpdateFileTotalChunks(taskID, fileInfoObj.TotalChunks)
-	fm.dbManager.UpdateFileTotalLines(taskID, fileInfoObj.TotalLines)
+	// 更新状态为分割完成，统计信息与状态一起写入，守护进程不会读到只更新了一半的任务
+	if err := fm.dbManager.UpdateFileSplitResult(fileInfoObj); err != nil {
+		return nil, fmt.Errorf("更新分割结果失败: %v", err)
+	}
 	fileInfoObj.Status = FileStatusSplitCompleted
 
 	return fileInfoObj, nil
 }
 
-// SaveFile 保存文件
-func (fm *FileManager) SaveFile(taskID string, chunkID string, fileContent string, isError bool) error {
-	// 获取chunk信息以确定retry值
-	chunk, err := fm.dbManager.GetChunk(chunkID)
-	if err != nil {
-		return err
-	}
-	if chunk == nil {
-		return fmt.Errorf("chunk不存在: %s", chunkID)
+// discardTask 删除未启动任务的数据库记录、分块文件与缓存结果，之后可以用相同的 task_id 重新提交
+func (fm *FileManager) discardTask(taskID string) {
+	if err := fm.dbManager.DeleteFile(taskID); err != nil {
+		logError("删除任务 %s 失败: %v", taskID, err)
 	}
+	os.RemoveAll(filepath.Join(CHUNK_DIR, taskID))
+	os.RemoveAll(filepath.Join(BATCH_RESULT_DIR, taskID))
+	os.Remove(rejectedInputPath(taskID))
+}
 
-	retry := chunk.Retry
+// mergedFilePath 合并结果文件路径，任务开启压缩时追加压缩扩展名
+func mergedFilePath(fileInfo *FileInfo, name string) string {
+	return withCompressionExt(filepath.Join(MERGED_DIR, fileInfo.TaskID, name), fileInfo.Compression)
+}
 
-	var path string
+// ResultFilePath 文件块结果文件的保存路径：batch_result/<task_id>/{output,error}/retry<N>_<chunk_id>.jsonl
+func ResultFilePath(chunk *FileChunk, isError bool) string {
+	kind := "output"
 	if isError {
-		path = filepath.Join(BATCH_RESULT_DIR, taskID, "error")
-	} else {
-		path = filepath.Join(BATCH_RESULT_DIR, taskID, "output")
+		kind = "error"
 	}
+	return filepath.Join(BATCH_RESULT_DIR, chunk.TaskID, kind, fmt.Sprintf("retry%d_%s.jsonl", chunk.Retry, chunk.ChunkID))
+}
 
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return err
-	}
+// mergeWriters 一轮合并中各结果文件的写入器
+type mergeWriters struct {
+	output   *lineWriter
+	error    *lineWriter
+	missing  *lineWriter
+	failed   *lineWriter
+	terminal *lineWriter
+}
 
-	filePath := filepath.Join(path, fmt.Sprintf("retry%d_%s.jsonl", retry, chunkID))
-	return os.WriteFile(filePath, []byte(fileContent), 0644)
+// Close 关闭所有写入器，返回第一个错误
+func (w *mergeWriters) Close() error {
+	var firstErr error
+	for _, writer := range []*lineWriter{w.output, w.error, w.missing, w.failed, w.terminal} {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // MergeBatchResults 合并chunk的output和error文件，并找出缺失的记录
+// 结果逐行流式写入，内存中只保留单个chunk的 custom_id 集合，占用与任务大小无关
 func (fm *FileManager) MergeBatchResults(taskID string, retry int) (map[string]interface{}, error) {
 	fileInfo, err := fm.dbManager.GetFile(taskID)
 	if err != nil || fileInfo == nil {
@@ -368,7 +534,8 @@ func (fm *FileManager) MergeBatchResults(taskID string, retry int) (map[string]i
 		}
 	}
 
-	if len(chunks) == 0 {
+	// 全部命中缓存时没有chunk，直接进入最终合并
+	if len(chunks) == 0 && !(retry == 0 && fileInfo.CachedLines > 0) {
 		return nil, fmt.Errorf("没有找到retry=%d的chunks", retry)
 	}
 
@@ -377,214 +544,337 @@ func (fm *FileManager) MergeBatchResults(taskID string, retry int) (map[string]i
 		return chunks[i].ChunkIndex < chunks[j].ChunkIndex
 	})
 
-	// 用于存储所有output和error记录
-	allOutputLines := []string{}
-	allErrorLines := []string{}
-	missingRecords := []string{}
-
 	// 确定输出文件名（根据retry参数）
-	outputMergedPath := filepath.Join(mergedDir, fmt.Sprintf("output_retry%d.jsonl", retry))
-	errorMergedPath := filepath.Join(mergedDir, fmt.Sprintf("error_retry%d.jsonl", retry))
-	missingRecordsPath := filepath.Join(mergedDir, fmt.Sprintf("missing_records_retry%d.jsonl", retry))
+	outputMergedPath := mergedFilePath(fileInfo, fmt.Sprintf("output_retry%d.jsonl", retry))
+	errorMergedPath := mergedFilePath(fileInfo, fmt.Sprintf("error_retry%d.jsonl", retry))
+	missingRecordsPath := mergedFilePath(fileInfo, fmt.Sprintf("missing_records_retry%d.jsonl", retry))
+	failedRecordsPath := mergedFilePath(fileInfo, fmt.Sprintf("failed_records_retry%d.jsonl", retry))
+	terminalErrorsPath := mergedFilePath(fileInfo, fmt.Sprintf("terminal_errors_retry%d.jsonl", retry))
+
+	writers := &mergeWriters{}
+	defer writers.Close()
+	if writers.output, err = createLineWriter(outputMergedPath); err != nil {
+		return nil, err
+	}
+	if writers.error, err = createLineWriter(errorMergedPath); err != nil {
+		return nil, err
+	}
+	if writers.missing, err = createLineWriter(missingRecordsPath); err != nil {
+		return nil, err
+	}
+	if writers.failed, err = createLineWriter(failedRecordsPath); err != nil {
+		return nil, err
+	}
+	if writers.terminal, err = createLineWriter(terminalErrorsPath); err != nil {
+		return nil, err
+	}
 
 	// 处理所有chunks
 	for _, chunk := range chunks {
-		// 读取chunk文件，获取所有custom_id
-		chunkPath := chunk.ChunkPath
-		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
-			logInfo("警告: chunk文件不存在: %s", chunkPath)
-			continue
+		if err := fm.mergeChunk(taskID, chunk, retry, writers); err != nil {
+			return nil, fmt.Errorf("合并chunk %s 失败: %v", chunk.ChunkID, err)
 		}
+	}
+	if err := fm.dbManager.UpdateFileUsage(taskID); err != nil {
+		logError("更新任务token用量失败: %v", err)
+	}
 
-		chunkCustomIDs := make(map[string]bool)
-		chunkRecords := make(map[string]map[string]interface{})
+	if err := writers.Close(); err != nil {
+		return nil, fmt.Errorf("写入合并文件失败: %v", err)
+	}
 
-		file, err := os.Open(chunkPath)
-		if err != nil {
-			continue
+	result := map[string]interface{}{
+		"output_file":          outputMergedPath,
+		"error_file":           errorMergedPath,
+		"missing_records_file": missingRecordsPath,
+		"missing_count":        writers.missing.count,
+		"failed_records_file":  failedRecordsPath,
+		"failed_count":         writers.failed.count,
+		"terminal_errors_file": terminalErrorsPath,
+		"terminal_error_count": writers.terminal.count,
+	}
+
+	// 使用文件表中的 max_retry 字段，而不是全局的 MAX_RETRY_COUNT
+	maxRetry := fileInfo.MaxRetry
+	if retry == maxRetry || writers.missing.count+writers.failed.count == 0 {
+		// 合并之前所有retry级别的output文件，以及分割时命中缓存的结果
+		finalOutputPath := mergedFilePath(fileInfo, "output.jsonl")
+		outputPaths := []string{}
+		for retryLevel := 0; retryLevel <= retry; retryLevel++ {
+			outputPaths = append(outputPaths, mergedFilePath(fileInfo, fmt.Sprintf("output_retry%d.jsonl", retryLevel)))
 		}
+		outputPaths = append(outputPaths, cachedOutputPath(fileInfo))
 
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
-			}
+		// 最终结果写入失败时任务标记为失败，不能把不完整的output当作完成的结果
+		failFinalMerge := func(step string, err error) error {
+			errorMsg := fmt.Sprintf("%s失败: %v", step, err)
+			fm.dbManager.UpdateFileStatus(taskID, FileStatusFailed, &errorMsg)
+			fileInfo.Status = FileStatusFailed
+			return errors.New(errorMsg)
+		}
 
-			var record map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &record); err != nil {
-				logInfo("警告: 解析chunk记录失败: %v", err)
-				continue
+		var finalOutputCount int
+		if SORT_OUTPUT {
+			// 按原始输入顺序排序，使输出第i行对应输入第i行
+			keyFunc, err := inputOrderSortKey(fileInfo)
+			if err != nil {
+				logError("读取原始输入顺序失败，按custom_id排序: %v", err)
+				keyFunc = customIDSortKey
 			}
-
-			customID, _ := record["custom_id"].(string)
-			chunkCustomIDs[customID] = true
-			chunkRecords[customID] = record
-		}
-		file.Close()
-
-		// 读取output文件（根据retry值选择文件名）
-		outputFile := filepath.Join(BATCH_RESULT_DIR, taskID, "output", fmt.Sprintf("retry%d_%s.jsonl", chunk.Retry, chunk.ChunkID))
-		outputCustomIDs := make(map[string]bool)
-
-		if _, err := os.Stat(outputFile); err == nil {
-			file, err := os.Open(outputFile)
-			if err == nil {
-				scanner := bufio.NewScanner(file)
-				for scanner.Scan() {
-					line := strings.TrimSpace(scanner.Text())
-					if line == "" {
-						continue
-					}
-
-					var record map[string]interface{}
-					if err := json.Unmarshal([]byte(line), &record); err != nil {
-						logInfo("警告: 解析output记录失败: %v", err)
-						continue
-					}
-
-					customID, _ := record["custom_id"].(string)
-					outputCustomIDs[customID] = true
-					allOutputLines = append(allOutputLines, line)
-				}
-				file.Close()
+			finalOutputCount, err = externalSortLines(outputPaths, finalOutputPath, keyFunc)
+			if err != nil {
+				return nil, failFinalMerge("排序合并output", err)
+			}
+		} else {
+			finalOutputCount, err = concatLines(finalOutputPath, outputPaths)
+			if err != nil {
+				return nil, failFinalMerge("合并output", err)
 			}
 		}
 
-		// 读取error文件（根据retry值选择文件名）
-		errorFile := filepath.Join(BATCH_RESULT_DIR, taskID, "error", fmt.Sprintf("retry%d_%s.jsonl", chunk.Retry, chunk.ChunkID))
-
-		if _, err := os.Stat(errorFile); err == nil {
-			file, err := os.Open(errorFile)
-			if err == nil {
-				scanner := bufio.NewScanner(file)
-				for scanner.Scan() {
-					line := strings.TrimSpace(scanner.Text())
-					if line != "" {
-						allErrorLines = append(allErrorLines, line)
-					}
-				}
-				file.Close()
-			}
+		// 合并所有retry级别的不可重试错误
+		finalTerminalPath := mergedFilePath(fileInfo, "terminal_errors.jsonl")
+		terminalPaths := []string{}
+		for retryLevel := 0; retryLevel <= retry; retryLevel++ {
+			terminalPaths = append(terminalPaths, mergedFilePath(fileInfo, fmt.Sprintf("terminal_errors_retry%d.jsonl", retryLevel)))
+		}
+		finalTerminalCount, err := concatLines(finalTerminalPath, terminalPaths)
+		if err != nil {
+			return nil, failFinalMerge("合并不可重试错误", err)
 		}
 
-		// 检查是否有缺失的记录
-		missingCustomIDs := []string{}
-		for customID := range chunkCustomIDs {
-			if !outputCustomIDs[customID] {
-				missingCustomIDs = append(missingCustomIDs, customID)
+		logInfo("最终合并完成: output=%d条, 不可重试错误=%d条", finalOutputCount, finalTerminalCount)
+		logInfo("最终文件路径: output=%s, terminal_errors=%s", finalOutputPath, finalTerminalPath)
+
+		result["final_output_file"] = finalOutputPath
+		result["final_terminal_errors_file"] = finalTerminalPath
+
+		// 将结果关联回原始输入记录
+		if JoinConf.Enabled {
+			joinedPath, err := fm.JoinOutput(taskID)
+			if err != nil {
+				logError("关联原始输入失败: %v", err)
+			} else {
+				result["joined_output_file"] = joinedPath
 			}
 		}
 
-		// 如果completed_count != total_count，检查并保存缺失的记录
-		if chunk.BatchTaskInfo != nil && chunk.BatchTaskInfo.CompletedCount != chunk.BatchTaskInfo.TotalCount {
-			for _, customID := range missingCustomIDs {
-				if record, ok := chunkRecords[customID]; ok {
-					recordJSON, _ := json.Marshal(record)
-					missingRecords = append(missingRecords, string(recordJSON))
-					logInfo("发现缺失记录: chunk_id=%s, custom_id=%s, completed=%d, total=%d",
-						chunk.ChunkID, customID, chunk.BatchTaskInfo.CompletedCount, chunk.BatchTaskInfo.TotalCount)
-				}
-			}
-		} else if len(missingCustomIDs) > 0 {
-			logInfo("警告: chunk_id=%s 虽然completed_count==total_count，但发现缺失记录: %d条", chunk.ChunkID, len(missingCustomIDs))
-			for _, customID := range missingCustomIDs {
-				if record, ok := chunkRecords[customID]; ok {
-					recordJSON, _ := json.Marshal(record)
-					missingRecords = append(missingRecords, string(recordJSON))
+		// 导出扁平表格
+		if len(ExportConf.Formats) > 0 {
+			tablePaths, err := fm.ExportTable(taskID, ExportConf.Formats)
+			if err != nil {
+				logError("导出表格失败: %v", err)
+			} else {
+				for format, path := range tablePaths {
+					result[format+"_table_file"] = path
 				}
 			}
 		}
+
+		fm.dbManager.UpdateFileStatus(taskID, FileStatusProcessCompleted, nil)
+		fileInfo.Status = FileStatusProcessCompleted
 	}
 
-	// 写入合并后的output文件
-	outputFile, err := os.Create(outputMergedPath)
-	if err == nil {
-		for _, line := range allOutputLines {
-			outputFile.WriteString(line + "\n")
-		}
-		outputFile.Close()
+	logInfo("合并完成: output=%d条, error=%d条, 失败=%d条, 不可重试=%d条, 缺失=%d条",
+		writers.output.count, writers.error.count, writers.failed.count, writers.terminal.count, writers.missing.count)
+
+	return result, nil
+}
+
+// mergeChunk 合并单个chunk的结果：output、error逐行写入合并文件并先写入逐行状态，
+// 再按 records 表中本轮失败、不可重试与缺失的记录写出对应文件
+func (fm *FileManager) mergeChunk(taskID string, chunk *FileChunk, retry int, writers *mergeWriters) error {
+	chunkPath := chunk.ChunkPath
+	if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
+		logInfo("警告: chunk文件不存在: %s", chunkPath)
+		return nil
 	}
 
-	// 写入合并后的error文件
-	errorFile, err := os.Create(errorMergedPath)
-	if err == nil {
-		for _, line := range allErrorLines {
-			errorFile.WriteString(line + "\n")
-		}
-		errorFile.Close()
+	if err := fm.ensureChunkRecords(taskID, chunk, retry); err != nil {
+		return fmt.Errorf("补齐chunk %s 的逐行状态失败: %v", chunk.ChunkID, err)
 	}
 
-	// 写入缺失记录文件
-	missingFile, err := os.Create(missingRecordsPath)
-	if err == nil {
-		for _, record := range missingRecords {
-			missingFile.WriteString(record + "\n")
+	// 开启缓存时先读取chunk文件中各请求的哈希，成功结果按哈希写入缓存
+	requestHashes := make(map[string]string)
+	if CACHE_ENABLED {
+		err := forEachLine(chunkPath, func(line string) error {
+			// 与分割时一样保留数字原文，请求哈希与 TryWrite 计算的一致
+			record, err := decodeJSONRecord([]byte(line))
+			if err != nil {
+				logInfo("警告: 解析chunk记录失败: %v", err)
+				return nil
+			}
+			customID, _ := record["custom_id"].(string)
+			requestHashes[customID] = requestHash(record)
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		missingFile.Close()
 	}
 
-	result := map[string]interface{}{
-		"output_file":          outputMergedPath,
-		"error_file":           errorMergedPath,
-		"missing_records_file": missingRecordsPath,
-		"missing_count":        len(missingRecords),
-	}
+	// 读取output文件（根据retry值选择文件名）
+	outputFile := ResultFilePath(chunk, false)
+	// 成功记录在 output_retry<N>.jsonl 中的偏移与失败记录的错误，写入逐行状态
+	outputOffsets := make(map[string]int64)
+	errorInfos := make(map[string]ErrorInfo)
+	var usage TokenUsage
 
-	// 使用文件表中的 max_retry 字段，而不是全局的 MAX_RETRY_COUNT
-	maxRetry := fileInfo.MaxRetry
-	if retry == maxRetry || len(missingRecords) == 0 {
-		// 合并之前所有retry级别的output文件
-		finalOutputPath := filepath.Join(mergedDir, "output.jsonl")
+	err := forEachLine(outputFile, func(line string) error {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			logInfo("警告: 解析output记录失败: %v", err)
+			return nil
+		}
 
-		// 用于存储所有output记录
-		allFinalOutputLines := []string{}
+		customID, _ := record["custom_id"].(string)
+		// output中带有error的记录按失败处理
+		if record["error"] != nil {
+			errorInfos[customID] = ClassifyErrorLine(record)
+			return writers.error.WriteLine(line)
+		}
+		outputOffsets[customID] = writers.output.size
+		usage.Add(ParseUsage(ParseCompletion(record).Usage))
 
-		// 按retry级别从0到maxRetry读取并合并
-		for retryLevel := 0; retryLevel <= retry; retryLevel++ {
-			retryOutputPath := filepath.Join(mergedDir, fmt.Sprintf("output_retry%d.jsonl", retryLevel))
-
-			if _, err := os.Stat(retryOutputPath); err == nil {
-				file, err := os.Open(retryOutputPath)
-				if err == nil {
-					scanner := bufio.NewScanner(file)
-					for scanner.Scan() {
-						line := strings.TrimSpace(scanner.Text())
-						if line != "" {
-							allFinalOutputLines = append(allFinalOutputLines, line)
-						}
-					}
-					file.Close()
-				}
+		// 成功结果写入缓存，供之后相同的请求复用
+		if hash, ok := requestHashes[customID]; ok {
+			if err := fm.cache.Put(hash, line); err != nil {
+				logInfo("警告: 写入结果缓存失败: %v", err)
 			}
 		}
+		return writers.output.WriteLine(line)
+	})
+	if err != nil {
+		return err
+	}
+	chunk.Usage = usage
+	if err := fm.dbManager.UpdateChunkUsage(chunk.ChunkID, usage); err != nil {
+		logError("更新chunk %s 的token用量失败: %v", chunk.ChunkID, err)
+	}
 
-		// 写入最终的output文件
-		finalFile, err := os.Create(finalOutputPath)
-		if err == nil {
-			for _, line := range allFinalOutputLines {
-				finalFile.WriteString(line + "\n")
-			}
-			finalFile.Close()
+	// 读取error文件（根据retry值选择文件名）
+	errorFile := ResultFilePath(chunk, true)
+	err = forEachLine(errorFile, func(line string) error {
+		if err := writers.error.WriteLine(line); err != nil {
+			return err
 		}
 
-		logInfo("最终合并完成: output=%d条", len(allFinalOutputLines))
-		logInfo("最终文件路径: output=%s", finalOutputPath)
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			logInfo("警告: 解析error记录失败: %v", err)
+			return nil
+		}
+		customID, _ := record["custom_id"].(string)
+		errorInfos[customID] = ClassifyErrorLine(record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		result["final_output_file"] = finalOutputPath
+	// 先写入逐行状态：成功、失败（按分类区分可重试与不可重试），output、error中都没有的记录标记为缺失
+	if err := fm.dbManager.MergeChunkRecords(chunk.ChunkID, outputOffsets, errorInfos); err != nil {
+		return fmt.Errorf("更新chunk %s 的逐行状态失败: %v", chunk.ChunkID, err)
+	}
 
-		fm.dbManager.UpdateFileStatus(taskID, FileStatusProcessCompleted, nil)
-		fileInfo.Status = FileStatusProcessCompleted
+	// 再从 records 表读取本轮未完成的记录：不可重试的直接记录原因，
+	// 可重试的失败记录与缺失记录需要写回原始请求行，进入下一轮重试
+	records, err := fm.dbManager.GetChunkUnfinishedRecords(taskID, chunk.ChunkID, retry)
+	if err != nil {
+		return fmt.Errorf("查询chunk %s 的未完成记录失败: %v", chunk.ChunkID, err)
+	}
+	countMismatch := chunk.BatchTaskInfo != nil && chunk.BatchTaskInfo.CompletedCount != chunk.BatchTaskInfo.TotalCount
+	retryStatuses := make(map[string]string)
+	failedCount, terminalCount, missingCount := 0, 0, 0
+	for _, record := range records {
+		switch record.Status {
+		case RecordStatusTerminal:
+			failedCount++
+			terminalCount++
+			info := record.ErrorInfo()
+			terminalJSON, _ := json.Marshal(map[string]interface{}{
+				"custom_id":   record.CustomID,
+				"chunk_id":    chunk.ChunkID,
+				"retry":       retry,
+				"category":    info.Category,
+				"status_code": info.StatusCode,
+				"code":        info.Code,
+				"message":     info.Message,
+			})
+			if err := writers.terminal.WriteLine(string(terminalJSON)); err != nil {
+				return err
+			}
+		case RecordStatusFailed:
+			failedCount++
+			retryStatuses[record.CustomID] = record.Status
+		default:
+			missingCount++
+			retryStatuses[record.CustomID] = record.Status
+			if countMismatch {
+				logInfo("发现缺失记录: chunk_id=%s, custom_id=%s, completed=%d, total=%d",
+					chunk.ChunkID, record.CustomID, chunk.BatchTaskInfo.CompletedCount, chunk.BatchTaskInfo.TotalCount)
+			}
+		}
 	}
 
-	logInfo("合并完成: output=%d条, error=%d条, 缺失=%d条", len(allOutputLines), len(allErrorLines), len(missingRecords))
+	if len(retryStatuses) > 0 {
+		err = forEachLine(chunkPath, func(line string) error {
+			customID := lineCustomID(line)
+			status, ok := retryStatuses[customID]
+			if !ok {
+				return nil
+			}
+			// 同一custom_id只写出一次
+			delete(retryStatuses, customID)
+			if status == RecordStatusFailed {
+				return writers.failed.WriteLine(line)
+			}
+			return writers.missing.WriteLine(line)
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	return result, nil
+	if failedCount > 0 {
+		logInfo("chunk_id=%s 发现失败记录: %d条（不可重试: %d条）", chunk.ChunkID, failedCount, terminalCount)
+	}
+	// 如果completed_count == total_count，不应该有缺失记录
+	if !countMismatch && missingCount > 0 {
+		logInfo("警告: chunk_id=%s 虽然completed_count==total_count，但发现缺失记录: %d条", chunk.ChunkID, missingCount)
+	}
+	return nil
+}
+
+// ensureChunkRecords 旧任务的文件块没有逐行状态，合并前按chunk文件中的custom_id补齐（原始行号未知，记为0），
+// 之后与新任务一样由 records 表决定失败和缺失的记录
+func (fm *FileManager) ensureChunkRecords(taskID string, chunk *FileChunk, retry int) error {
+	count, err := fm.dbManager.CountChunkRecords(chunk.ChunkID)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	var records []*Record
+	err = forEachLine(chunk.ChunkPath, func(line string) error {
+		records = append(records, &Record{
+			TaskID:   taskID,
+			CustomID: lineCustomID(line),
+			ChunkID:  &chunk.ChunkID,
+			Retry:    retry,
+			Status:   RecordStatusProcessed,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logInfo("chunk_id=%s 没有逐行状态，已按chunk文件补齐 %d 条", chunk.ChunkID, len(records))
+	return fm.dbManager.AddRecords(records)
 }
 
-// RetryFailedRecords 重试失败数据
+// RetryFailedRecords 重试失败和缺失的数据
 func (fm *FileManager) RetryFailedRecords(taskID string) (bool, error) {
-	fileInfo, err := fm.dbManager.GetFile(taskID)
+	fileInfo, err := fm.dbManager.GetFileHeader(taskID)
 	if err != nil || fileInfo == nil {
 		return false, fmt.Errorf("文件不存在: %s", taskID)
 	}
@@ -594,31 +884,48 @@ func (fm *FileManager) RetryFailedRecords(taskID string) (bool, error) {
 	}
 
 	// 检查missing_records.jsonl是否存在且有数据（根据当前retry值选择文件）
-	missingRecordsPath := filepath.Join(MERGED_DIR, taskID, fmt.Sprintf("missing_records_retry%d.jsonl", fileInfo.Retry))
+	missingRecordsPath := mergedFilePath(fileInfo, fmt.Sprintf("missing_records_retry%d.jsonl", fileInfo.Retry))
+	failedRecordsPath := mergedFilePath(fileInfo, fmt.Sprintf("failed_records_retry%d.jsonl", fileInfo.Retry))
 
 	if _, err := os.Stat(missingRecordsPath); os.IsNotExist(err) {
 		return false, fmt.Errorf("缺失记录文件不存在: %s", missingRecordsPath)
 	}
 
-	// 读取缺失记录
-	missingRecords := []string{}
-	file, err := os.Open(missingRecordsPath)
-	if err == nil {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line != "" {
-				missingRecords = append(missingRecords, line)
-			}
-		}
-		file.Close()
+	// 统计缺失记录和失败记录（失败记录文件在旧任务中可能不存在）
+	missingCount, err := countLines(missingRecordsPath)
+	if err != nil {
+		return false, err
+	}
+	failedCount, err := countLines(failedRecordsPath)
+	if err != nil {
+		return false, err
 	}
 
-	if len(missingRecords) == 0 {
+	if missingCount+failedCount == 0 {
 		return true, nil
 	}
 
-	logInfo("发现 %d 条缺失记录，开始重试...", len(missingRecords))
+	logInfo("发现 %d 条缺失记录、%d 条失败记录，开始重试...", missingCount, failedCount)
+
+	// 已产生的用量加上本轮重试的预估用量超出预算时暂停任务，调整预算后可通过 -resume 继续
+	projected := fileInfo.Usage
+	for _, recordsPath := range []string{missingRecordsPath, failedRecordsPath} {
+		err := forEachLine(recordsPath, func(recordLine string) error {
+			projected.Add(estimateRequestLine(recordLine))
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := checkBudget(usageModel(fileInfo), projected); err != nil {
+		errorMsg := fmt.Sprintf("第%d轮重试将超出预算，任务已暂停: %v", fileInfo.Retry+1, err)
+		logInfo("[%s] %s", taskID, errorMsg)
+		if err := fm.dbManager.UpdateFileStatus(taskID, FileStatusPaused, &errorMsg); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
 
 	// 更新重试次数
 	newRetry := fileInfo.Retry + 1
@@ -633,20 +940,37 @@ func (fm *FileManager) RetryFailedRecords(taskID string) (bool, error) {
 		return false, err
 	}
 
-	// 分割缺失记录
+	// 逐行读取缺失和失败记录并分块，内存中最多保留一个块
 	chunkIndex := 0
 	currentChunkLines := []string{}
+	// 为每条重试记录新增一轮逐行状态
+	saveRecords := func() {
+		customIDs := make([]string, 0, len(currentChunkLines))
+		for _, line := range currentChunkLines {
+			customIDs = append(customIDs, lineCustomID(line))
+		}
+		if err := fm.dbManager.AddRetryRecords(taskID, fm.generateChunkID(taskID, chunkIndex, newRetry), newRetry, customIDs); err != nil {
+			logError("保存逐行状态失败: %v", err)
+		}
+	}
 
-	for _, recordLine := range missingRecords {
-		currentChunkLines = append(currentChunkLines, recordLine)
+	for _, recordsPath := range []string{missingRecordsPath, failedRecordsPath} {
+		err := forEachLine(recordsPath, func(recordLine string) error {
+			currentChunkLines = append(currentChunkLines, recordLine)
 
-		// 当达到指定行数时，写入一个块
-		if len(currentChunkLines) >= LINES_PER_CHUNK {
-			if err := fm.writeChunk(taskID, chunkIndex, fileInfo.OriginalFilename, chunkDir, currentChunkLines, fileInfo, newRetry); err != nil {
-				return false, err
+			// 当达到指定行数时，写入一个块
+			if len(currentChunkLines) >= LINES_PER_CHUNK {
+				if err := fm.writeChunk(taskID, chunkIndex, fileInfo.OriginalFilename, chunkDir, currentChunkLines, fileInfo, newRetry); err != nil {
+					return err
+				}
+				saveRecords()
+				chunkIndex++
+				currentChunkLines = []string{}
 			}
-			chunkIndex++
-			currentChunkLines = []string{}
+			return nil
+		})
+		if err != nil {
+			return false, err
 		}
 	}
 
@@ -655,6 +979,7 @@ func (fm *FileManager) RetryFailedRecords(taskID string) (bool, error) {
 		if err := fm.writeChunk(taskID, chunkIndex, fileInfo.OriginalFilename, chunkDir, currentChunkLines, fileInfo, newRetry); err != nil {
 			return false, err
 		}
+		saveRecords()
 		chunkIndex++
 	}
 
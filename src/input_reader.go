@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// 支持的输入格式
+const (
+	InputFormatJSONL   = "jsonl"
+	InputFormatCSV     = "csv"
+	InputFormatParquet = "parquet"
+)
+
+// INPUT_FORMAT 命令行 -input-format 指定的输入格式，为空时按扩展名判断
+var INPUT_FORMAT = ""
+
+// RecordError 单条记录无法解析，跳过该条继续读取
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("err in line %d: %v", e.Line, e.Err)
+}
+
+// decodeJSONRecord 解析一条 JSON 对象，数字保留为 json.Number（原始文本），
+// 超过 2^53 的整数 id 不会因转换为 float64 而丢失精度，重新序列化时也与输入一致
+func decodeJSONRecord(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var record map[string]interface{}
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("JSON 对象之后存在多余内容")
+	}
+	return record, nil
+}
+
+// InputReader 逐条读取输入文件，每条记录转换为一个map
+type InputReader interface {
+	// Next 返回下一条记录及其行号（JSONL为物理行号，CSV、Parquet为数据行序号，均从1开始），
+	// 记录无法解析时返回 *RecordError，读完时返回 io.EOF
+	Next() (map[string]interface{}, int, error)
+	// Raw 返回最近一次 Next 读取的原始文本（JSONL为原始行，CSV为该行重新编码的CSV，Parquet为该行的JSON），无法获取时为空
+	Raw() string
+	Close() error
+}
+
+// detectInputFormat 确定输入格式：优先使用指定的格式，否则按扩展名（忽略 .gz/.zst）判断，默认 jsonl
+func detectInputFormat(path string, format string) (string, error) {
+	if format != "" {
+		switch format {
+		case InputFormatJSONL, InputFormatCSV, InputFormatParquet:
+			return format, nil
+		default:
+			return "", fmt.Errorf("不支持的输入格式: %s（可选 jsonl、csv、parquet）", format)
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(trimCompressionExt(path))) {
+	case ".csv":
+		return InputFormatCSV, nil
+	case ".parquet":
+		return InputFormatParquet, nil
+	default:
+		return InputFormatJSONL, nil
+	}
+}
+
+// openInputReader 按格式打开输入文件
+func openInputReader(path string, format string) (InputReader, error) {
+	switch format {
+	case InputFormatCSV:
+		return newCSVInputReader(path)
+	case InputFormatParquet:
+		return newParquetInputReader(path)
+	default:
+		return newJSONLInputReader(path)
+	}
+}
+
+// forEachInputRecord 遍历输入文件中可解析且能构建出messages的记录（SplitFile 是否提交还要经过 buildBatchRequest 的检查），
+// 回调记录、行号与原始文本（见 InputReader.Raw）
+func forEachInputRecord(path string, format string, fn func(record map[string]interface{}, lineNumber int, raw string) error) error {
+	reader, err := openInputReader(path, format)
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer reader.Close()
+
+	for {
+		record, lineNumber, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := buildMessages(record); err != nil {
+			continue
+		}
+
+		if err := fn(record, lineNumber, reader.Raw()); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonlInputReader JSONL 输入，空行跳过但计入行号
+type jsonlInputReader struct {
+	file   io.ReadCloser
+	reader *bufio.Reader
+	line   int
+	raw    string
+}
+
+func newJSONLInputReader(path string) (*jsonlInputReader, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlInputReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+func (r *jsonlInputReader) Next() (map[string]interface{}, int, error) {
+	for {
+		text, err := r.reader.ReadString('\n')
+		if text == "" && err != nil {
+			return nil, r.line, err
+		}
+		r.line++
+
+		line := strings.TrimSpace(text)
+		if line == "" {
+			continue
+		}
+		r.raw = line
+		record, err := decodeJSONRecord([]byte(line))
+		if err != nil {
+			return nil, r.line, &RecordError{Line: r.line, Err: err}
+		}
+		return record, r.line, nil
+	}
+}
+
+func (r *jsonlInputReader) Raw() string {
+	return r.raw
+}
+
+func (r *jsonlInputReader) Close() error {
+	return r.file.Close()
+}
+
+// csvInputReader 带表头的 CSV 输入，每个单元格为字符串
+type csvInputReader struct {
+	file   io.ReadCloser
+	reader *csv.Reader
+	header []string
+	line   int
+	fields []string
+}
+
+func newCSVInputReader(path string) (*csvInputReader, error) {
+	file, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		file.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("CSV 文件为空: %s", path)
+		}
+		return nil, fmt.Errorf("读取 CSV 表头失败: %v", err)
+	}
+	// 去掉 Excel 导出文件开头的 BOM
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	return &csvInputReader{file: file, reader: reader, header: header}, nil
+}
+
+func (r *csvInputReader) Next() (map[string]interface{}, int, error) {
+	fields, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, r.line, io.EOF
+	}
+	r.line++
+	r.fields = fields
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return nil, r.line, &RecordError{Line: r.line, Err: err}
+		}
+		return nil, r.line, err
+	}
+	if len(fields) != len(r.header) {
+		return nil, r.line, &RecordError{Line: r.line, Err: fmt.Errorf("列数 %d 与表头列数 %d 不一致", len(fields), len(r.header))}
+	}
+
+	record := make(map[string]interface{}, len(fields))
+	for i, name := range r.header {
+		record[name] = fields[i]
+	}
+	return record, r.line, nil
+}
+
+func (r *csvInputReader) Raw() string {
+	if r.fields == nil {
+		return ""
+	}
+	var buf strings.Builder
+	writer := csv.NewWriter(&buf)
+	writer.Write(r.fields)
+	writer.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+func (r *csvInputReader) Close() error {
+	return r.file.Close()
+}
+
+// parquetInputReader Parquet 输入（需要随机读取，不支持压缩扩展名）
+type parquetInputReader struct {
+	file   *os.File
+	reader *parquet.Reader
+	line   int
+	raw    string
+}
+
+func newParquetInputReader(path string) (*parquetInputReader, error) {
+	if compressionOf(path) != CompressionNone {
+		return nil, fmt.Errorf("Parquet 文件不支持外层压缩: %s", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("打开 Parquet 文件失败: %v", err)
+	}
+	return &parquetInputReader{file: file, reader: parquet.NewReader(pf)}, nil
+}
+
+func (r *parquetInputReader) Next() (map[string]interface{}, int, error) {
+	row := map[string]interface{}{}
+	if err := r.reader.Read(&row); err != nil {
+		return nil, r.line, err
+	}
+	r.line++
+	r.raw = ""
+
+	// 经 JSON 转换一次，使数值、列表等类型与 JSONL 输入一致（数字为 json.Number，列表为 []interface{}）
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, r.line, &RecordError{Line: r.line, Err: err}
+	}
+	r.raw = string(data)
+	record, err := decodeJSONRecord(data)
+	if err != nil {
+		return nil, r.line, &RecordError{Line: r.line, Err: err}
+	}
+	return record, r.line, nil
+}
+
+func (r *parquetInputReader) Raw() string {
+	return r.raw
+}
+
+func (r *parquetInputReader) Close() error {
+	r.reader.Close()
+	return r.file.Close()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxRequestLineBytes 单行batch请求的最大字节数，与原先读取输入时的 10MB 行长限制一致，超出的行分割时跳过
+const maxRequestLineBytes = 10 * 1024 * 1024
+
+// 校验问题的分类
+const (
+	IssueInvalidJSON       = "invalid_json"
+	IssueMissingMessages   = "missing_messages"
+	IssueInvalidMessage    = "invalid_message"
+	IssueEmptyContent      = "empty_content"
+	IssueInvalidCustomID   = "invalid_custom_id"
+	IssueDuplicateCustomID = "duplicate_custom_id"
+	IssueOversizeLine      = "oversize_line"
+)
+
+// validRoles messages 中允许的 role
+var validRoles = map[string]bool{
+	"system":    true,
+	"developer": true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+	"function":  true,
+}
+
+// errEmptyContent content 为空字符串或空数组
+var errEmptyContent = errors.New("message格式错误：content为空")
+
+// ValidationIssue 输入文件中一行的问题
+type ValidationIssue struct {
+	Line     int
+	Category string
+	Reason   string
+}
+
+// ValidationReport 输入文件的校验结果，统计项只包含能通过校验、会被提交的行
+type ValidationReport struct {
+	TotalLines   int            // 读取到的记录数（JSONL 不含空行）
+	ValidLines   int            // 通过校验的记录数
+	IssueCounts  map[string]int // 各分类的问题行数
+	Chunks       int            // 按 lines_per_chunk 与 100M 上限预计的分块数
+	RequestBytes int64          // 生成的batch请求总字节数
+	Estimate     TokenUsage     // 预估输入 token 与最大输出 token
+}
+
+// IssueCount 问题行总数
+func (r *ValidationReport) IssueCount() int {
+	return r.TotalLines - r.ValidLines
+}
+
+// ValidateMessage 校验单条 message 的 role 与 content
+func ValidateMessage(message interface{}) error {
+	//将interface{}类型断言为map[string]interface{}（JSON对象解析后的标准类型）
+	msgMap, ok := message.(map[string]interface{})
+	if !ok {
+		return errors.New("message格式错误：非有效的JSON对象类型")
+	}
+
+	//校验必选字段role - 存在性 + 字符串类型 + 取值
+	roleVal, hasRole := msgMap["role"]
+	if !hasRole {
+		return errors.New("message格式错误：缺少必选字段role")
+	}
+	role, ok := roleVal.(string)
+	if !ok {
+		return errors.New("message格式错误：role字段必须为字符串类型")
+	}
+	if !validRoles[role] {
+		return fmt.Errorf("message格式错误：不支持的role %q", role)
+	}
+
+	//校验content字段 - 存在性 + 字符串/数组类型（JSON数组解析后为[]interface{}）
+	contentVal, hasContent := msgMap["content"]
+	if !hasContent {
+		return errors.New("message格式错误：缺少字段content")
+	}
+	// 判断content是否为字符串 或 数组（[]interface{}），带 tool_calls 的 assistant 消息允许 content 为空
+	_, hasToolCalls := msgMap["tool_calls"]
+	switch content := contentVal.(type) {
+	case string:
+		if strings.TrimSpace(content) == "" && !hasToolCalls {
+			return errEmptyContent
+		}
+	case []interface{}:
+		if len(content) == 0 && !hasToolCalls {
+			return errEmptyContent
+		}
+	case nil:
+		if !hasToolCalls {
+			return errEmptyContent
+		}
+	default:
+		return errors.New("message格式错误：content字段必须为字符串或数组类型")
+	}
+
+	// 所有校验通过
+	return nil
+}
+
+// CheckFileFormat 按 SplitFile 的方式遍历整个输入文件（忽略 test_lines），逐行校验并回调每个问题，
+// 不创建任务、不写入分块；返回分块数、请求字节数与 token 预估
+func CheckFileFormat(filePath string, format string, onIssue func(issue ValidationIssue)) (*ValidationReport, error) {
+	reader, err := openInputReader(filePath, format)
+	if err != nil {
+		return nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer reader.Close()
+
+	report := &ValidationReport{IssueCounts: make(map[string]int)}
+	addIssue := func(line int, category string, reason string) {
+		report.IssueCounts[category]++
+		onIssue(ValidationIssue{Line: line, Category: category, Reason: reason})
+	}
+
+	seenCustomIDs := make(map[string]int)
+	chunkLines, chunkBytes := 0, 0
+	for {
+		record, lineNumber, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			report.TotalLines++
+			addIssue(recordErr.Line, IssueInvalidJSON, recordErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取文件错误: %v", err)
+		}
+		report.TotalLines++
+
+		// 与 buildBatchRequest 相同的检查顺序，以下问题 SplitFile 同样跳过，写入 rejected_input.jsonl
+		messages, err := buildMessages(record)
+		if err != nil {
+			addIssue(lineNumber, IssueMissingMessages, err.Error())
+			continue
+		}
+		customID, err := recordCustomID(record, CUSTOM_ID_KEY, lineNumber)
+		if err != nil {
+			addIssue(lineNumber, IssueInvalidCustomID, err.Error())
+			continue
+		}
+		if category, err := validateRequestMessages(messages); err != nil {
+			addIssue(lineNumber, category, err.Error())
+			continue
+		}
+		requestLine, err := json.Marshal(buildRequestLine(customID, messages))
+		if err != nil {
+			addIssue(lineNumber, IssueInvalidMessage, err.Error())
+			continue
+		}
+		lineSize := len(requestLine) + 1
+		if err := checkRequestLineSize(lineSize); err != nil {
+			addIssue(lineNumber, IssueOversizeLine, err.Error())
+			continue
+		}
+
+		// 与 CheckDuplicateCustomIDs 一致：只有会被提交的行参与重复检查，重复的 custom_id 使任务无法创建
+		if firstLine, ok := seenCustomIDs[customID]; ok {
+			addIssue(lineNumber, IssueDuplicateCustomID, fmt.Sprintf("custom_id %q 与第%d行重复", customID, firstLine))
+			continue
+		}
+		seenCustomIDs[customID] = lineNumber
+
+		// 与 SplitFile 相同的分块规则
+		if (chunkBytes+lineSize > maxChunkBytes || chunkLines+1 > LINES_PER_CHUNK) && chunkLines > 0 {
+			report.Chunks++
+			chunkLines, chunkBytes = 0, 0
+		}
+		chunkLines++
+		chunkBytes += lineSize
+
+		report.ValidLines++
+		report.RequestBytes += int64(lineSize)
+		report.Estimate.Add(TokenUsage{PromptTokens: int64(estimateMessagesTokens(messages)), CompletionTokens: int64(ModelConf.MaxTokens)})
+	}
+	if chunkLines > 0 {
+		report.Chunks++
+	}
+	return report, nil
+}
+
+// validateRequestMessages 校验一条记录构建出的 messages，返回第一个问题的分类；校验不通过的行 SplitFile 不提交
+func validateRequestMessages(messages []interface{}) (string, error) {
+	if len(messages) == 0 {
+		return IssueMissingMessages, errors.New("messages为空")
+	}
+	return validateMessages(messages)
+}
+
+// checkRequestLineSize 单行batch请求（含换行符）不能超过 maxRequestLineBytes
+func checkRequestLineSize(lineSize int) error {
+	if lineSize > maxRequestLineBytes {
+		return fmt.Errorf("请求大小 %d 字节超过 %d 字节上限", lineSize, maxRequestLineBytes)
+	}
+	return nil
+}
+
+// validateMessages 校验每条 message，返回第一个问题的分类
+func validateMessages(messages []interface{}) (string, error) {
+	for i, message := range messages {
+		if err := ValidateMessage(message); err != nil {
+			category := IssueInvalidMessage
+			if err == errEmptyContent {
+				category = IssueEmptyContent
+			}
+			return category, fmt.Errorf("messages[%d] %v", i, err)
+		}
+	}
+	return "", nil
+}
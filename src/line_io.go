@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// forEachLine 逐行读取文件中的非空行（不限制行长度，压缩文件边读边解压），文件不存在时直接返回
+func forEachLine(path string, fn func(line string) error) error {
+	file, err := openFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			if err := fn(trimmed); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// countLines 统计文件中的非空行数，文件不存在时返回0
+func countLines(path string) (int, error) {
+	count := 0
+	err := forEachLine(path, func(line string) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// lineWriter 带缓冲的逐行写入器，合并结果时边读边写，不在内存中保留整个文件
+type lineWriter struct {
+	file   io.WriteCloser
+	writer *bufio.Writer
+	count  int
+	size   int64 // 已写入的字节数（压缩前），即下一行的偏移量
+}
+
+// createLineWriter 创建（覆盖）文件并返回逐行写入器，路径以 .gz / .zst 结尾时压缩写入
+func createLineWriter(path string) (*lineWriter, error) {
+	file, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &lineWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// WriteLine 写入一行（自动追加换行符）
+func (w *lineWriter) WriteLine(line string) error {
+	w.writer.WriteString(line)
+	if err := w.writer.WriteByte('\n'); err != nil {
+		return err
+	}
+	w.count++
+	w.size += int64(len(line)) + 1
+	return nil
+}
+
+// Close 刷新并关闭文件，可重复调用
+func (w *lineWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	file := w.file
+	w.file = nil
+	if err := w.writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// concatLines 依次将多个文件的非空行写入 outputPath（不存在的文件跳过），返回写入的行数
+func concatLines(outputPath string, inputPaths []string) (int, error) {
+	writer, err := createLineWriter(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	defer writer.Close()
+
+	for _, path := range inputPaths {
+		if err := forEachLine(path, writer.WriteLine); err != nil {
+			return 0, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return writer.count, nil
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -19,27 +23,30 @@ import (
 type BatchInferService struct {
 	dbManager       *DBManager
 	fileManager     *FileManager
-	batchManager    *BatchManager
+	provider        BatchProvider
 	chunkManager    *ChunkManager
 	progress        *ProgressDisplay
 	processingFiles map[string]bool // 正在处理的文件集合
 	processingMutex sync.Mutex      // 保护 processingFiles 的互斥锁
+	submittingTasks map[string]bool // 正在通过控制接口提交（分割）的任务
+	submitMutex     sync.Mutex      // 保护 submittingTasks 的互斥锁
 }
 
 // NewBatchInferService 创建批量推理服务
 func NewBatchInferService() *BatchInferService {
 	dbManager := NewDBManager()
 	fileManager := NewFileManager(dbManager)
-	batchManager := NewBatchManager()
-	chunkManager := NewChunkManager(dbManager, fileManager, batchManager)
+	provider := NewBatchProvider()
+	chunkManager := NewChunkManager(dbManager, fileManager, provider)
 
 	return &BatchInferService{
 		dbManager:       dbManager,
 		fileManager:     fileManager,
-		batchManager:    batchManager,
+		provider:        provider,
 		chunkManager:    chunkManager,
 		progress:        NewProgressDisPlay(),
 		processingFiles: make(map[string]bool),
+		submittingTasks: make(map[string]bool),
 	}
 }
 
@@ -66,7 +73,7 @@ func (bis *BatchInferService) SplitFile(filePath string, taskId string, linesPer
 	filename := filepath.Base(filePath)
 	bis.progress.Update(fmt.Sprintf("开始分割文件: %s (每块行数: %d)", filename, lines))
 
-	fileInfo, err := bis.fileManager.SplitFile(filePath, filename, taskId, lines)
+	fileInfo, err := bis.fileManager.SplitFile(filePath, filename, taskId, INPUT_FORMAT, lines)
 	if err != nil {
 		bis.progress.Update(fmt.Sprintf("✗ 文件分割失败: %v", err))
 		return "", err
@@ -77,6 +84,60 @@ func (bis *BatchInferService) SplitFile(filePath string, taskId string, linesPer
 	return fileInfo.TaskID, nil
 }
 
+// SubmitTask 分割输入文件创建任务并立即开始调度（控制接口提交任务时调用），originalFilename 为空时使用文件名
+func (bis *BatchInferService) SubmitTask(req SubmitRequest, originalFilename string) (*FileInfo, error) {
+	if err := validateTaskID(req.TaskID); err != nil {
+		return nil, err
+	}
+	// 守护进程的工作目录与调用方不同，只接受绝对路径
+	if !filepath.IsAbs(req.FilePath) {
+		return nil, fmt.Errorf("%w: file_path 需要是绝对路径: %s", errInvalidRequest, req.FilePath)
+	}
+	if _, err := os.Stat(req.FilePath); err != nil {
+		return nil, fmt.Errorf("%w: 文件不存在: %s", errInvalidRequest, req.FilePath)
+	}
+	lines := LINES_PER_CHUNK
+	if req.LinesPerChunk != nil {
+		lines = *req.LinesPerChunk
+	}
+	if lines <= 0 || lines > 50000 {
+		return nil, fmt.Errorf("%w: lines_per_chunk 需要在 1-50000 之间", errInvalidRequest)
+	}
+
+	// 同一 task_id 的并发提交只有一个能进入分割
+	bis.submitMutex.Lock()
+	if bis.submittingTasks[req.TaskID] {
+		bis.submitMutex.Unlock()
+		return nil, fmt.Errorf("%w: 任务正在提交: %s", errTaskState, req.TaskID)
+	}
+	bis.submittingTasks[req.TaskID] = true
+	bis.submitMutex.Unlock()
+	defer func() {
+		bis.submitMutex.Lock()
+		delete(bis.submittingTasks, req.TaskID)
+		bis.submitMutex.Unlock()
+	}()
+
+	existing, err := bis.dbManager.GetFileHeader(req.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("%w: 任务已存在: %s", errTaskState, req.TaskID)
+	}
+
+	if originalFilename == "" {
+		originalFilename = filepath.Base(req.FilePath)
+	}
+	logInfo("[%s] 提交任务: %s (每块行数: %d)", req.TaskID, req.FilePath, lines)
+	fileInfo, err := bis.fileManager.SplitFile(req.FilePath, originalFilename, req.TaskID, req.InputFormat, lines)
+	if err != nil {
+		return nil, err
+	}
+	go bis.ProcessFile(fileInfo.TaskID)
+	return fileInfo, nil
+}
+
 // UploadChunks 上传文件块（无并发限制）
 func (bis *BatchInferService) UploadChunks(taskID string) int {
 	fileInfo, err := bis.ValidateFileExists(taskID)
@@ -190,6 +251,12 @@ func (bis *BatchInferService) UploadAndProcessLoop(taskID string) {
 			break
 		}
 
+		// 任务被取消或暂停后停止调度，暂停期间已提交的batch在继续后再检查
+		if fileInfo.Status == FileStatusCanceled || fileInfo.Status == FileStatusPaused {
+			bis.progress.Update(fmt.Sprintf("任务状态为 %s，停止调度: %s", fileInfo.Status, taskID))
+			break
+		}
+
 		// 统计各状态的数量
 		pendingCount := 0
 		uploadedCount := 0
@@ -278,7 +345,7 @@ func (bis *BatchInferService) MergeFile(taskID string) (map[string]interface{},
 			}
 		}
 
-		return nil, fmt.Errorf(errorMsg)
+		return nil, errors.New(errorMsg)
 	}
 
 	bis.progress.Update(fmt.Sprintf("开始合并文件: %s", taskID))
@@ -300,22 +367,137 @@ func (bis *BatchInferService) MergeFile(taskID string) (map[string]interface{},
 	return mergedDict, nil
 }
 
-// Cancel 终止调度
-func (bis *BatchInferService) Cancel(taskID string) {
+// JoinOutput 将已完成任务的结果关联回原始输入记录
+func (bis *BatchInferService) JoinOutput(taskID string) {
+	fileInfo, err := bis.ValidateFileExists(taskID)
+	if err != nil {
+		logError("%v", err)
+		return
+	}
+	if fileInfo.Status != FileStatusProcessCompleted {
+		logError("任务尚未完成合并，当前状态: %s", fileInfo.Status)
+		return
+	}
+
+	joinedPath, err := bis.fileManager.JoinOutput(taskID)
+	if err != nil {
+		logError("关联原始输入失败: %v", err)
+		return
+	}
+	fmt.Printf("关联结果已写入: %s\n", joinedPath)
+}
+
+// ExportTable 将已完成任务的结果导出为扁平表格，formats 为空时使用配置中的 export.formats，仍为空时导出 csv
+func (bis *BatchInferService) ExportTable(taskID string, formats []string) {
+	fileInfo, err := bis.ValidateFileExists(taskID)
+	if err != nil {
+		logError("%v", err)
+		return
+	}
+	if fileInfo.Status != FileStatusProcessCompleted {
+		logError("任务尚未完成合并，当前状态: %s", fileInfo.Status)
+		return
+	}
+
+	if len(formats) == 0 {
+		formats = ExportConf.Formats
+	}
+	if len(formats) == 0 {
+		formats = []string{ExportFormatCSV}
+	}
+	paths, err := bis.fileManager.ExportTable(taskID, formats)
+	if err != nil {
+		logError("导出表格失败: %v", err)
+		return
+	}
+	for format, path := range paths {
+		fmt.Printf("%s 表格已写入: %s\n", format, path)
+	}
+}
+
+// ShowUsage 显示任务的 token 用量与预估费用
+func (bis *BatchInferService) ShowUsage(taskID string) {
 	fileInfo, err := bis.ValidateFileExists(taskID)
+	if err != nil {
+		logError("%v", err)
+		return
+	}
+	bis.progress.ShowUsage(fileInfo)
+}
+
+// recordListLimit -record 不指定 custom_id 时最多列出的未成功记录数
+const recordListLimit = 50
+
+// ShowRecord 显示一条记录在各轮重试中的状态；不指定 customID 时按状态统计，并列出未成功的记录
+func (bis *BatchInferService) ShowRecord(taskID string, customID string) {
+	fileInfo, err := bis.ValidateFileExists(taskID)
+	if err != nil {
+		logError("%v", err)
+		return
+	}
+
+	if customID == "" {
+		counts, err := bis.dbManager.CountRecords(taskID)
+		if err != nil {
+			logError("查询逐行状态失败: %v", err)
+			return
+		}
+		if len(counts) == 0 {
+			logError("任务 %s 没有逐行状态（在逐行记录功能之前创建的任务）", taskID)
+			return
+		}
+		unfinished, err := bis.dbManager.GetUnfinishedRecords(taskID, recordListLimit)
+		if err != nil {
+			logError("查询逐行状态失败: %v", err)
+			return
+		}
+		bis.progress.ShowRecordSummary(fileInfo, counts, unfinished, recordListLimit)
+		return
+	}
+
+	records, err := bis.dbManager.GetRecordHistory(taskID, customID)
+	if err != nil {
+		logError("查询逐行状态失败: %v", err)
+		return
+	}
+	if len(records) == 0 {
+		logError("任务 %s 中没有 custom_id=%s 的记录", taskID, customID)
+		return
+	}
+	bis.progress.ShowRecordHistory(fileInfo, records)
+}
+
+// Cancel 终止调度（守护进程运行时由守护进程执行）
+func (bis *BatchInferService) Cancel(taskID string) {
+	fileInfo, err := bis.runTaskAction(taskID, "cancel", bis.CancelTask)
 	if err != nil {
 		logError("取消文件失败: %s", err)
 		return
 	}
+	bis.progress.ShowStatus(fileInfo, true)
+	bis.progress.Update(fmt.Sprintf("✓ 文件调度已终止: %s", taskID))
+}
+
+// CancelTask 将任务设置为 CANCELED，取消处理中的batch任务，已上传未提交的chunk设置为 CANCELED
+func (bis *BatchInferService) CancelTask(taskID string) (*FileInfo, error) {
+	fileInfo, err := bis.loadTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Status == FileStatusProcessCompleted || fileInfo.Status == FileStatusFailed || fileInfo.Status == FileStatusCanceled {
+		return nil, fmt.Errorf("%w: 任务已结束，当前状态: %s", errTaskState, fileInfo.Status)
+	}
 
 	// 将file_info的状态设置成canceled
-	bis.dbManager.UpdateFileStatus(taskID, FileStatusCanceled, nil)
-	bis.progress.Update(fmt.Sprintf("文件状态已设置为 CANCELED: %s", taskID))
+	if err := bis.dbManager.UpdateFileStatus(taskID, FileStatusCanceled, nil); err != nil {
+		return nil, err
+	}
+	logInfo("文件状态已设置为 CANCELED: %s", taskID)
 
 	// 对processing的chunk调用cancelBatchTask
 	for _, chunk := range fileInfo.Chunks {
 		if chunk.Status == ChunkStatusProcessing && chunk.BatchID != nil {
-			_, err := bis.batchManager.CancelBatchTask(*chunk.BatchID)
+			_, err := bis.provider.CancelBatchTask(*chunk.BatchID)
 			if err == nil {
 				logInfo("已取消batch任务: %s (chunk: %s)", *chunk.BatchID, chunk.ChunkID)
 			} else {
@@ -332,12 +514,68 @@ func (bis *BatchInferService) Cancel(taskID string) {
 		}
 	}
 
-	// 刷新并显示状态
-	fileInfo, _ = bis.dbManager.GetFile(taskID)
-	if fileInfo != nil {
-		bis.progress.ShowStatus(fileInfo, true)
+	return bis.loadTask(taskID)
+}
+
+// Pause 手动暂停任务（守护进程运行时由守护进程执行）
+func (bis *BatchInferService) Pause(taskID string) {
+	fileInfo, err := bis.runTaskAction(taskID, "pause", bis.PauseTask)
+	if err != nil {
+		logError("暂停任务失败: %s", err)
+		return
 	}
-	bis.progress.Update(fmt.Sprintf("✓ 文件调度已终止: %s", taskID))
+	bis.progress.ShowStatus(fileInfo, true)
+	bis.progress.Update(fmt.Sprintf("✓ 任务已暂停，使用 -resume 继续: %s", taskID))
+}
+
+// PauseTask 暂停分割完成或处理中的任务：不再上传和提交新的chunk，已提交的batch继续在服务端运行，继续后再检查
+func (bis *BatchInferService) PauseTask(taskID string) (*FileInfo, error) {
+	fileInfo, err := bis.loadTaskHeader(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Status != FileStatusSplitCompleted && fileInfo.Status != FileStatusProcessing {
+		return nil, fmt.Errorf("%w: 只能暂停分割完成或处理中的任务，当前状态: %s", errTaskState, fileInfo.Status)
+	}
+
+	errorMsg := "手动暂停"
+	if err := bis.dbManager.UpdateFileStatus(taskID, FileStatusPaused, &errorMsg); err != nil {
+		return nil, err
+	}
+	logInfo("任务已暂停: %s", taskID)
+	return bis.loadTask(taskID)
+}
+
+// Resume 继续暂停的任务（守护进程运行时由守护进程执行并立即调度），下一轮重试前会按当前配置重新检查预算
+func (bis *BatchInferService) Resume(taskID string) {
+	fileInfo, err := bis.runTaskAction(taskID, "resume", bis.ResumeTask)
+	if err != nil {
+		logError("继续任务失败: %s", err)
+		return
+	}
+	bis.progress.ShowStatus(fileInfo, true)
+	bis.progress.Update(fmt.Sprintf("✓ 任务已继续: %s", taskID))
+}
+
+// ResumeTask 将暂停（超出预算或手动暂停）的任务恢复为处理中
+func (bis *BatchInferService) ResumeTask(taskID string) (*FileInfo, error) {
+	fileInfo, err := bis.loadTaskHeader(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Status != FileStatusPaused {
+		return nil, fmt.Errorf("%w: 任务未暂停，当前状态: %s", errTaskState, fileInfo.Status)
+	}
+	// 守护进程只在启动时加载配置，重新读取 budget 与 pricing，调高的预算在下一轮重试前生效
+	if err := ReloadBudgetConfig(); err != nil {
+		return nil, fmt.Errorf("%w: 重新加载预算配置失败: %v", errInvalidRequest, err)
+	}
+
+	if err := bis.dbManager.UpdateFileStatus(taskID, FileStatusProcessing, nil); err != nil {
+		return nil, err
+	}
+	logInfo("任务已继续: %s", taskID)
+	return bis.loadTask(taskID)
 }
 
 // QueryStatus 查询并更新文件状态
@@ -370,7 +608,7 @@ func (bis *BatchInferService) MonitorStatus(taskID string) {
 // MonitorSingleFile 监控单个文件状态（固定刷新间隔10秒）
 func (bis *BatchInferService) MonitorSingleFile(taskID string) {
 	fmt.Printf("开始监控文件状态: %s (刷新间隔: 10秒)\n", taskID)
-	fmt.Println("按 Ctrl+C 停止监控\n")
+	fmt.Print("按 Ctrl+C 停止监控\n\n")
 
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
@@ -378,9 +616,9 @@ func (bis *BatchInferService) MonitorSingleFile(taskID string) {
 	for {
 		select {
 		case <-ticker.C:
-			fileInfo, err := bis.dbManager.GetFile(taskID)
-			if err != nil || fileInfo == nil {
-				fmt.Printf("文件不存在: %s\n", taskID)
+			fileInfo, err := bis.fetchTask(taskID)
+			if err != nil {
+				fmt.Printf("读取任务状态失败: %v\n", err)
 				return
 			}
 
@@ -390,16 +628,19 @@ func (bis *BatchInferService) MonitorSingleFile(taskID string) {
 			bis.progress.ShowStatus(fileInfo, true)
 
 			// 检查是否完成
+			errorMsg := "未知错误"
+			if fileInfo.ErrorMessage != nil {
+				errorMsg = *fileInfo.ErrorMessage
+			}
 			if fileInfo.Status == FileStatusProcessCompleted {
 				fmt.Println("\n✓ 所有流程已完成！")
 				return
 			} else if fileInfo.Status == FileStatusFailed {
-				errorMsg := "未知错误"
-				if fileInfo.ErrorMessage != nil {
-					errorMsg = *fileInfo.ErrorMessage
-				}
 				fmt.Printf("\n✗ 流程失败: %s\n", errorMsg)
 				return
+			} else if fileInfo.Status == FileStatusPaused {
+				fmt.Printf("\n‖ 任务已暂停: %s\n", errorMsg)
+				return
 			}
 		}
 	}
@@ -408,7 +649,7 @@ func (bis *BatchInferService) MonitorSingleFile(taskID string) {
 // MonitorAllFiles 监控所有进行中的文件（固定刷新间隔10秒）
 func (bis *BatchInferService) MonitorAllFiles() {
 	fmt.Printf("开始监控所有进行中的文件 (刷新间隔: 10秒)\n")
-	fmt.Println("按 Ctrl+C 停止监控\n")
+	fmt.Print("按 Ctrl+C 停止监控\n\n")
 
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
@@ -417,7 +658,7 @@ func (bis *BatchInferService) MonitorAllFiles() {
 		select {
 		case <-ticker.C:
 			// 获取所有进行中的文件（分割完成或处理中）
-			taskIDs, err := bis.dbManager.GetPendingFiles()
+			fileInfos, err := bis.fetchActiveTasks()
 			if err != nil {
 				fmt.Printf("获取文件列表失败: %v\n", err)
 				continue
@@ -426,35 +667,29 @@ func (bis *BatchInferService) MonitorAllFiles() {
 			// 清屏
 			clearScreenFunc()
 			fmt.Printf("=== 所有进行中的文件监控 ===\n\n")
-			fmt.Printf("文件总数: %d\n\n", len(taskIDs))
+			fmt.Printf("文件总数: %d\n\n", len(fileInfos))
 
-			if len(taskIDs) == 0 {
+			if len(fileInfos) == 0 {
 				fmt.Println("当前没有进行中的文件")
 				continue
 			}
 
 			// 显示每个文件的状态
-			for _, taskID := range taskIDs {
-				fileInfo, err := bis.dbManager.GetFile(taskID)
-				if err != nil || fileInfo == nil {
-					continue
-				}
-
+			for _, fileInfo := range fileInfos {
 				bis.progress.ShowStatus(fileInfo, false)
 				fmt.Println() // 空行分隔
 			}
 
-			// 检查是否所有文件都已完成
+			// 检查是否所有文件都已完成（复用本轮读取的文件信息）
 			allCompleted := true
-			for _, taskID := range taskIDs {
-				fileInfo, _ := bis.dbManager.GetFile(taskID)
-				if fileInfo != nil && fileInfo.Status != FileStatusProcessCompleted && fileInfo.Status != FileStatusFailed && fileInfo.Status != FileStatusCanceled {
+			for _, fileInfo := range fileInfos {
+				if fileInfo.Status != FileStatusProcessCompleted && fileInfo.Status != FileStatusFailed && fileInfo.Status != FileStatusCanceled {
 					allCompleted = false
 					break
 				}
 			}
 
-			if allCompleted && len(taskIDs) > 0 {
+			if allCompleted {
 				fmt.Println("\n✓ 所有文件处理完成！")
 				return
 			}
@@ -466,7 +701,7 @@ func (bis *BatchInferService) MonitorAllFiles() {
 func (bis *BatchInferService) RunPipeline(filePath string, taskId string, linesPerChunk *int) {
 	var taskID string
 
-	fileInfo, err := bis.dbManager.GetFile(taskId)
+	fileInfo, err := bis.dbManager.GetFileHeader(taskId)
 	if err != nil {
 		logError("执行错误:%s", err)
 		os.Exit(1)
@@ -476,35 +711,78 @@ func (bis *BatchInferService) RunPipeline(filePath string, taskId string, linesP
 		return
 	}
 
-	if _, err := os.Stat(filePath); err == nil {
-		// 是文件路径，执行分割
+	if _, err := os.Stat(filePath); err != nil {
+		logError("文件错误:%s", err)
+		os.Exit(1)
+	}
+
+	// 守护进程运行时由它分割并立即调度，否则在本进程中分割，等待守护进程启动后的第一次扫描
+	err = bis.submitToDaemon(filePath, taskId, linesPerChunk)
+	if err == nil {
+		taskID = taskId
+	} else if errors.Is(err, errDaemonUnavailable) {
 		logInfo("开始分割文件---------------------------")
-		var err error
 		taskID, err = bis.SplitFile(filePath, taskId, linesPerChunk)
 		if err != nil {
 			logError("流程执行失败: %v", err)
 			os.Exit(1)
 		}
 	} else {
-		logError("文件错误:%s", err)
+		logError("提交任务到守护进程失败: %v", err)
 		os.Exit(1)
 	}
 
 	for {
 		time.Sleep(10 * time.Second)
-		fileInfo, err := bis.dbManager.GetFile(taskID)
-		if err != nil {
+		fileInfo, err := bis.dbManager.GetFileHeader(taskID)
+		if err != nil || fileInfo == nil {
 			continue
 		}
 		if fileInfo.Status == FileStatusProcessCompleted || fileInfo.Status == FileStatusFailed || fileInfo.Status == FileStatusCanceled {
 			logInfo("文件 %s 已结束，状态为 %s", taskID, fileInfo.Status)
 			break
 		}
-		bis.progress.ShowStatus(fileInfo, true)
+		if fileInfo.Status == FileStatusPaused {
+			errorMsg := "未知原因"
+			if fileInfo.ErrorMessage != nil {
+				errorMsg = *fileInfo.ErrorMessage
+			}
+			logInfo("文件 %s 已暂停: %s", taskID, errorMsg)
+			break
+		}
+		// 进度显示需要各文件块的状态，只在显示时加载
+		if fileInfo, err := bis.dbManager.GetFile(taskID); err == nil && fileInfo != nil {
+			bis.progress.ShowStatus(fileInfo, true)
+		}
 	}
 
 }
 
+// submitToDaemon 通过控制接口把 -pipeline 的输入文件提交给守护进程（与 POST /tasks 相同）；
+// 守护进程未运行时返回 errDaemonUnavailable
+func (bis *BatchInferService) submitToDaemon(filePath string, taskID string, linesPerChunk *int) error {
+	client, err := newControlClient()
+	if err != nil {
+		return err
+	}
+	// 守护进程的工作目录与当前进程不同，提交绝对路径
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+	status, err := client.Submit(SubmitRequest{
+		FilePath:      absPath,
+		TaskID:        taskID,
+		InputFormat:   INPUT_FORMAT,
+		LinesPerChunk: linesPerChunk,
+	})
+	if err != nil {
+		return err
+	}
+	logInfo("已提交到守护进程: %s，状态: %s", status.TaskID, status.Status)
+	return nil
+}
+
 // ProcessFile 处理单个文件的完整流程（从上传到重试）
 func (bis *BatchInferService) ProcessFile(taskID string) {
 	// 检查是否正在处理，如果是则跳过
@@ -536,7 +814,8 @@ func (bis *BatchInferService) ProcessFile(taskID string) {
 	// 检查文件状态，如果已经完成或取消，跳过
 	if fileInfo.Status == FileStatusProcessCompleted ||
 		fileInfo.Status == FileStatusCanceled ||
-		fileInfo.Status == FileStatusFailed {
+		fileInfo.Status == FileStatusFailed ||
+		fileInfo.Status == FileStatusPaused {
 		logInfo("文件 %s 状态为 %s，跳过处理", taskID, fileInfo.Status)
 		return
 	}
@@ -547,6 +826,11 @@ func (bis *BatchInferService) ProcessFile(taskID string) {
 	for i := fileInfo.Retry; i <= maxRetry; i++ {
 		logInfo("[%s] 开始上传和处理文件块（循环执行）-------------------", taskID)
 		bis.UploadAndProcessLoop(taskID)
+		if fileInfo, _ := bis.dbManager.GetFileHeader(taskID); fileInfo != nil &&
+			(fileInfo.Status == FileStatusCanceled || fileInfo.Status == FileStatusPaused) {
+			logInfo("[%s] 任务状态为 %s，停止处理", taskID, fileInfo.Status)
+			return
+		}
 		logInfo("[%s] 开始合并文件----------------------------", taskID)
 		_, err := bis.MergeFile(taskID)
 		if err != nil {
@@ -577,9 +861,9 @@ func (bis *BatchInferService) ProcessFile(taskID string) {
 func (bis *BatchInferService) runDaemonLoop(ctx context.Context) {
 	logInfo("========== 守护进程已启动（后台运行）==========")
 	if runtime.GOOS == "windows" {
-		logInfo("提示: 守护进程在后台运行，使用 taskkill /PID %d /F 命令停止", os.Getpid())
+		logInfo("提示: 守护进程在后台运行，使用 -stop-daemon 或 taskkill /PID %d /F 命令停止", os.Getpid())
 	} else {
-		logInfo("提示: 守护进程在后台运行，使用 kill -TERM %d 或 kill %d 命令停止", os.Getpid(), os.Getpid())
+		logInfo("提示: 守护进程在后台运行，使用 -stop-daemon、kill -TERM %d 或 kill %d 命令停止", os.Getpid(), os.Getpid())
 	}
 
 	ticker := time.NewTicker(60 * time.Second)
@@ -787,6 +1071,12 @@ func (bis *BatchInferService) RunDaemon() {
 
 // RunDaemonInternal 守护进程内部运行函数（由exec.Command启动的进程调用）
 func (bis *BatchInferService) RunDaemonInternal() {
+	bis.runDaemonProcess(nil, nil)
+}
+
+// runDaemonProcess 守护进程主体：创建锁文件，启动控制接口与调度循环，收到终止信号或停止请求后退出。
+// rest 不为空时（-serve）同时在 restListener 上提供 REST 接口，并响应 Ctrl+C
+func (bis *BatchInferService) runDaemonProcess(rest *http.Server, restListener net.Listener) {
 	logInfo("========== 守护进程内部运行 ==========")
 	logInfo("进程ID: %d", os.Getpid())
 	// logInfo("扫描间隔: 60 秒")
@@ -814,21 +1104,57 @@ func (bis *BatchInferService) RunDaemonInternal() {
 	// 创建信号通道，用于接收终止信号（SIGTERM等）
 	termChan := make(chan os.Signal, 1)
 
-	// 只注册终止信号，不注册SIGINT（Ctrl+C）
+	// 后台守护进程只注册终止信号，不注册SIGINT（Ctrl+C）
 	if runtime.GOOS == "windows" {
 		signal.Notify(termChan, syscall.SIGTERM)
 	} else {
 		signal.Notify(termChan, syscall.SIGTERM, syscall.SIGQUIT)
 	}
+	if rest != nil {
+		signal.Notify(termChan, os.Interrupt)
+	}
+
+	// 启动控制接口，收到 /stop 请求时与终止信号一样退出
+	stopChan := make(chan struct{}, 1)
+	control, err := bis.startControlServer(func() {
+		select {
+		case stopChan <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		logError("启动控制接口失败，CLI 将直接读写数据库: %v", err)
+	}
+
+	if rest != nil {
+		go func() {
+			if err := rest.Serve(restListener); err != nil && err != http.ErrServerClosed {
+				logError("REST 接口退出: %v", err)
+			}
+		}()
+	}
 
 	// 在独立的goroutine中启动守护进程（固定间隔60秒）
 	go bis.runDaemonLoop(ctx)
 
-	// 等待终止信号
-	sig := <-termChan
-	logInfo("收到终止信号: %v，正在停止守护进程...", sig)
+	// 等待终止信号或停止请求
+	select {
+	case sig := <-termChan:
+		logInfo("收到终止信号: %v，正在停止守护进程...", sig)
+	case <-stopChan:
+		logInfo("收到控制接口的停止请求，正在停止守护进程...")
+	}
+	if control != nil {
+		control.Close()
+	}
+	if rest != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		rest.Shutdown(shutdownCtx)
+		shutdownCancel()
+	}
 	cancel()
 	time.Sleep(1 * time.Second) // 给守护进程一点时间退出
+	bis.dbManager.Close()
 	os.Exit(0)
 }
 
@@ -874,21 +1200,90 @@ func (bis *BatchInferService) DeleteFile(taskID string) {
 	}
 }
 
+// ValidateFile 校验输入文件并打印每个问题行与预计的分块、字节、token 统计，不创建任务也不上传
+// 全部通过时返回 true
+func ValidateFile(filePath string) bool {
+	inputFormat, err := detectInputFormat(filePath, INPUT_FORMAT)
+	if err != nil {
+		logError("%v", err)
+		return false
+	}
+
+	fmt.Printf("校验文件: %s（格式: %s）\n\n", filePath, inputFormat)
+	report, err := CheckFileFormat(filePath, inputFormat, func(issue ValidationIssue) {
+		fmt.Printf("第%d行 [%s]: %s\n", issue.Line, issue.Category, issue.Reason)
+	})
+	if err != nil {
+		logError("校验失败: %v", err)
+		return false
+	}
+
+	fmt.Printf("\n总行数: %d | 有效行数: %d | 问题行数: %d\n", report.TotalLines, report.ValidLines, report.IssueCount())
+	if report.IssueCount() > 0 {
+		categories := make([]string, 0, len(report.IssueCounts))
+		for category := range report.IssueCounts {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			fmt.Printf("  %s: %d\n", category, report.IssueCounts[category])
+		}
+	}
+	fmt.Printf("预计分块数: %d（每块最多 %d 行）| 请求总大小: %d 字节\n", report.Chunks, LINES_PER_CHUNK, report.RequestBytes)
+	fmt.Printf("预估输入token: %d | 最大输出token: %d", report.Estimate.PromptTokens, report.Estimate.CompletionTokens)
+	if cost, ok := PricingConf.EstimateCost(ModelConf.Domain, report.Estimate); ok {
+		fmt.Printf(" | 最坏费用: %.4f %s", cost, PricingConf.Currency)
+	}
+	fmt.Println()
+	if TEST_LINES > 0 {
+		fmt.Printf("注意: test_lines=%d，实际运行时只会提交前 %d 条\n", TEST_LINES, TEST_LINES)
+	}
+	if err := checkBudget(ModelConf.Domain, report.Estimate); err != nil {
+		fmt.Printf("✗ 超出预算: %v\n", err)
+		return false
+	}
+	return report.IssueCount() == 0
+}
+
 func main() {
 	initLogger()
 	logInfo("========== 程序启动 ==========")
 
 	// var pipeline, split, upload, process, merge, taskId, cancel, monitor, deleteFile string
-	var pipeline, taskId, cancel, monitor string
+	var pipeline, validate, serve, taskId, cancel, pause, resume, monitor, join, export, exportFormat, usage, record string
 	var configPath string
 	var monitorProvided bool // 标记是否提供了 -monitor 参数
-	var daemonInternal bool
+	var daemonInternal, stopDaemon bool
+	var dbMigrate, dryRun bool
+	var mockOpts MockServerOptions
 
 	flag.StringVar(&configPath, "config", "", "模型配置文件路径（YAML格式），如果不指定则使用默认配置./config.yaml")
 	flag.StringVar(&pipeline, "pipeline", "", "数据文件路径,运行完整流程（分割->上传->处理->合并->重试->结束）")
+	flag.StringVar(&validate, "validate", "", "数据文件路径，只校验格式并统计分块数、字节数与token预估，不创建任务也不上传")
+	flag.StringVar(&serve, "serve", "", "以前台守护进程方式运行并在指定地址（如 :8080）提供 REST 接口，用于远程提交、查询、取消任务与下载结果")
 	flag.StringVar(&taskId, "task-id", "", "pipeline 传参，task_id不能为空")
+	flag.StringVar(&INPUT_FORMAT, "input-format", "", "pipeline/validate 传参，输入文件格式：jsonl、csv、parquet，不指定时按扩展名判断")
 	flag.StringVar(&cancel, "cancel", "", "具体task_id取消调度")
+	flag.StringVar(&pause, "pause", "", "暂停任务：不再上传和提交新的文件块，使用 -resume 继续")
+	flag.StringVar(&resume, "resume", "", "继续因超出预算或 -pause 而暂停的任务（重新读取配置文件中的 budget 与 pricing 后检查预算）")
 	flag.StringVar(&monitor, "monitor", "", "监控文件状态，不传task_id则显示所有进行中的文件")
+	flag.StringVar(&join, "join", "", "将已完成任务的结果关联回原始输入记录，生成 joined_output.jsonl")
+	flag.StringVar(&usage, "usage", "", "显示任务各文件块的token用量与预估费用（单价见配置中的 pricing）")
+	flag.StringVar(&record, "record", "", "显示task_id中一条记录（-record <task_id> <custom_id>）在各轮重试中的状态，不传custom_id时统计并列出未成功的记录")
+	flag.StringVar(&export, "export", "", "将已完成任务的结果导出为扁平表格（output_table.csv / output_table.parquet）")
+	flag.StringVar(&exportFormat, "export-format", "", "export 传参，导出格式，多个用逗号分隔：csv、parquet，不指定时使用配置中的 export.formats")
+
+	flag.StringVar(&mockOpts.Addr, "mock-server", "", "启动本地模拟batch服务（如 :8000），用于无凭证的端到端测试")
+	flag.DurationVar(&mockOpts.StepTime, "mock-step", 5*time.Second, "模拟服务中batch每个状态的停留时间")
+	flag.Float64Var(&mockOpts.FailRate, "mock-fail-rate", 0, "模拟服务中单条请求失败的概率（0-1）")
+	flag.Float64Var(&mockOpts.ExpireRate, "mock-expire-rate", 0, "模拟服务中batch过期的概率（0-1）")
+	flag.Float64Var(&mockOpts.MissingRate, "mock-missing-rate", 0, "模拟服务中单条请求结果缺失的概率（0-1）")
+	flag.Float64Var(&mockOpts.DropRate, "mock-drop-rate", 0, "模拟服务中下载结果文件时连接中途断开的概率（0-1）")
+
+	flag.BoolVar(&dbMigrate, "db-migrate", false, "将状态数据库迁移到最新版本（迁移前自动备份），程序启动时也会自动迁移")
+	flag.BoolVar(&dryRun, "dry-run", false, "db-migrate 传参，只显示当前版本与待执行的迁移步骤，不修改数据库")
+
+	flag.BoolVar(&stopDaemon, "stop-daemon", false, "通过控制接口通知守护进程退出")
 
 	flag.BoolVar(&daemonInternal, "daemon-internal", false, "内部标志：守护进程内部运行（不要手动使用）")
 
@@ -916,6 +1311,15 @@ func main() {
 	// 先解析一次以获取 configPath（如果提供了）
 	flag.Parse()
 
+	// 模拟服务不依赖模型配置
+	if mockOpts.Addr != "" {
+		if err := NewMockServer(mockOpts).ListenAndServe(); err != nil {
+			logError("模拟batch服务退出: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 加载配置文件
 	if configPath == "" {
 		// 如果没有指定配置文件路径，使用默认路径
@@ -926,6 +1330,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 校验不需要数据库和守护进程
+	if validate != "" {
+		if !ValidateFile(validate) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 迁移需要在打开数据库（自动迁移）之前处理
+	if dbMigrate {
+		if err := RunDBMigrate(dryRun); err != nil {
+			logError("数据库迁移失败: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 停止守护进程不需要打开数据库，也不能先启动守护进程
+	if stopDaemon {
+		if err := StopDaemon(); err != nil {
+			logError("停止守护进程失败: %v", err)
+			os.Exit(1)
+		}
+		logInfo("已通知守护进程退出")
+		return
+	}
+
 	// 检查是否提供了 -monitor 参数
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "monitor" {
@@ -935,12 +1366,18 @@ func main() {
 
 	logInfo("========== 开始执行 ==========")
 	service := NewBatchInferService()
+	defer service.dbManager.Close()
 
 	// 首先检查是否是守护进程内部运行
 	if daemonInternal {
 		service.RunDaemonInternal()
 		return
 	}
+	// -serve 本身就是守护进程，不再启动后台守护进程
+	if serve != "" {
+		service.Serve(serve)
+		return
+	}
 	go service.RunDaemon()
 	switch {
 	case pipeline != "":
@@ -951,6 +1388,22 @@ func main() {
 		service.RunPipeline(pipeline, taskId, nil)
 	case cancel != "":
 		service.Cancel(cancel)
+	case pause != "":
+		service.Pause(pause)
+	case resume != "":
+		service.Resume(resume)
+	case join != "":
+		service.JoinOutput(join)
+	case usage != "":
+		service.ShowUsage(usage)
+	case record != "":
+		service.ShowRecord(record, flag.Arg(0))
+	case export != "":
+		var formats []string
+		if exportFormat != "" {
+			formats = strings.Split(exportFormat, ",")
+		}
+		service.ExportTable(export, formats)
 	case monitorProvided:
 		service.MonitorStatus(monitor)
 	default:
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MockServerOptions 模拟batch服务参数
+type MockServerOptions struct {
+	Addr        string        // 监听地址，如 :8000
+	StepTime    time.Duration // 每个状态（queueing → in_progress → finalizing → completed）停留时间
+	FailRate    float64       // 单条请求进入error文件的概率
+	ExpireRate  float64       // 整个batch过期的概率（过期时只有前一半请求有结果）
+	MissingRate float64       // 单条请求既不在output也不在error中的概率
+	DropRate    float64       // 下载结果文件时连接中途断开的概率（只返回请求范围的前一半）
+}
+
+// mockOutcome 单条请求的模拟结果
+type mockOutcome int
+
+const (
+	mockOutcomeSucceeded mockOutcome = iota
+	mockOutcomeFailed
+	mockOutcomeMissing
+)
+
+// mockFile 内存中的文件
+type mockFile struct {
+	ID        string
+	Filename  string
+	Purpose   string
+	Content   []byte
+	CreatedAt time.Time
+}
+
+// mockRequest 输入文件中的一条请求
+type mockRequest struct {
+	CustomID string
+	Model    string
+	Prompt   int // 粗略估算的输入token数
+	Outcome  mockOutcome
+}
+
+// mockBatch 内存中的batch任务
+type mockBatch struct {
+	ID           string
+	InputFileID  string
+	Endpoint     string
+	Window       string
+	CreatedAt    time.Time
+	Requests     []*mockRequest
+	Expire       bool
+	Status       BatchStatus
+	CanceledAt   *time.Time
+	OutputFileID string
+	ErrorFileID  string
+	Completed    int
+	Failed       int
+}
+
+// MockServer 模拟 OpenAI 兼容的 /v1/files、/v1/batches 接口，状态只保存在内存中
+type MockServer struct {
+	opts    MockServerOptions
+	mu      sync.Mutex
+	rand    *rand.Rand
+	files   map[string]*mockFile
+	batches map[string]*mockBatch
+}
+
+// NewMockServer 创建模拟batch服务
+func NewMockServer(opts MockServerOptions) *MockServer {
+	if opts.StepTime <= 0 {
+		opts.StepTime = 5 * time.Second
+	}
+	return &MockServer{
+		opts:    opts,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		files:   make(map[string]*mockFile),
+		batches: make(map[string]*mockBatch),
+	}
+}
+
+// Handler 返回模拟服务的路由
+func (ms *MockServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/files", ms.handleUploadFile)
+	mux.HandleFunc("GET /v1/files", ms.handleListFiles)
+	mux.HandleFunc("GET /v1/files/{id}", ms.handleGetFile)
+	mux.HandleFunc("DELETE /v1/files/{id}", ms.handleDeleteFile)
+	mux.HandleFunc("GET /v1/files/{id}/content", ms.handleFileContent)
+	mux.HandleFunc("POST /v1/batches", ms.handleCreateBatch)
+	mux.HandleFunc("GET /v1/batches/{id}", ms.handleGetBatch)
+	mux.HandleFunc("POST /v1/batches/{id}/cancel", ms.handleCancelBatch)
+	return mux
+}
+
+// ListenAndServe 启动模拟服务（阻塞）
+func (ms *MockServer) ListenAndServe() error {
+	logInfo("模拟batch服务启动: %s (状态间隔: %s, 失败率: %.2f, 过期率: %.2f, 缺失率: %.2f, 下载断开率: %.2f)",
+		ms.opts.Addr, ms.opts.StepTime, ms.opts.FailRate, ms.opts.ExpireRate, ms.opts.MissingRate, ms.opts.DropRate)
+	logInfo("配置 provider: openai, base_url: http://127.0.0.1%s/v1 即可使用", ms.opts.Addr)
+	return http.ListenAndServe(ms.opts.Addr, ms.Handler())
+}
+
+// writeJSON 输出JSON响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeMockError 输出 OpenAI 风格的错误响应
+func writeMockError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    "invalid_request_error",
+		},
+	})
+}
+
+func (ms *MockServer) fileObject(f *mockFile) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         f.ID,
+		"object":     "file",
+		"bytes":      len(f.Content),
+		"created_at": f.CreatedAt.Unix(),
+		"filename":   f.Filename,
+		"purpose":    f.Purpose,
+	}
+}
+
+func (ms *MockServer) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeMockError(w, http.StatusBadRequest, fmt.Sprintf("缺少file字段: %v", err))
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeMockError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ms.mu.Lock()
+	f := ms.addFile(header.Filename, r.FormValue("purpose"), content)
+	obj := ms.fileObject(f)
+	ms.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, obj)
+}
+
+// addFile 保存文件（调用方持有锁）
+func (ms *MockServer) addFile(filename string, purpose string, content []byte) *mockFile {
+	f := &mockFile{
+		ID:        "file-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Filename:  filename,
+		Purpose:   purpose,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+	ms.files[f.ID] = f
+	return f
+}
+
+func (ms *MockServer) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	ms.mu.Lock()
+	data := []interface{}{}
+	for _, f := range ms.files {
+		data = append(data, ms.fileObject(f))
+	}
+	ms.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+func (ms *MockServer) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	ms.mu.Lock()
+	f, ok := ms.files[r.PathValue("id")]
+	var obj map[string]interface{}
+	if ok {
+		obj = ms.fileObject(f)
+	}
+	ms.mu.Unlock()
+
+	if !ok {
+		writeMockError(w, http.StatusNotFound, "文件不存在")
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (ms *MockServer) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ms.mu.Lock()
+	_, ok := ms.files[id]
+	delete(ms.files, id)
+	ms.mu.Unlock()
+
+	if !ok {
+		writeMockError(w, http.StatusNotFound, "文件不存在")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "object": "file", "deleted": true})
+}
+
+func (ms *MockServer) handleFileContent(w http.ResponseWriter, r *http.Request) {
+	ms.mu.Lock()
+	f, ok := ms.files[r.PathValue("id")]
+	drop := ms.rand.Float64() < ms.opts.DropRate
+	ms.mu.Unlock()
+
+	if !ok {
+		writeMockError(w, http.StatusNotFound, "文件不存在")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	var content io.ReadSeeker = bytes.NewReader(f.Content)
+	if drop {
+		content = &droppingReader{ReadSeeker: content, remaining: int64(len(f.Content)) / 2}
+	}
+	http.ServeContent(w, r, f.Filename, f.CreatedAt, content)
+}
+
+// droppingReader 读取 remaining 字节后返回错误，模拟下载过程中连接断开
+type droppingReader struct {
+	io.ReadSeeker
+	remaining int64
+}
+
+func (d *droppingReader) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		return 0, errors.New("mock: connection dropped")
+	}
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.ReadSeeker.Read(p)
+	d.remaining -= int64(n)
+	return n, err
+}
+
+func (ms *MockServer) handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		InputFileID      string `json:"input_file_id"`
+		Endpoint         string `json:"endpoint"`
+		CompletionWindow string `json:"completion_window"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeMockError(w, http.StatusBadRequest, fmt.Sprintf("请求体解析失败: %v", err))
+		return
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	f, ok := ms.files[body.InputFileID]
+	if !ok {
+		writeMockError(w, http.StatusBadRequest, "input_file_id 不存在")
+		return
+	}
+
+	requests, err := ms.parseRequests(f.Content)
+	if err != nil {
+		writeMockError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	batch := &mockBatch{
+		ID:          "batch_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		InputFileID: body.InputFileID,
+		Endpoint:    body.Endpoint,
+		Window:      body.CompletionWindow,
+		CreatedAt:   time.Now(),
+		Requests:    requests,
+		Expire:      ms.rand.Float64() < ms.opts.ExpireRate,
+		Status:      BatchStatusQueueing,
+	}
+	ms.batches[batch.ID] = batch
+	logInfo("模拟batch已创建: %s (请求数: %d, 过期: %v)", batch.ID, len(requests), batch.Expire)
+
+	writeJSON(w, http.StatusOK, ms.batchObject(batch))
+}
+
+// parseRequests 解析输入文件中的请求，并预先决定每条请求的结果（调用方持有锁）
+func (ms *MockServer) parseRequests(content []byte) ([]*mockRequest, error) {
+	var requests []*mockRequest
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record struct {
+			CustomID string `json:"custom_id"`
+			Body     struct {
+				Model string `json:"model"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("第 %d 条请求解析失败: %v", len(requests)+1, err)
+		}
+
+		outcome := mockOutcomeSucceeded
+		roll := ms.rand.Float64()
+		if roll < ms.opts.FailRate {
+			outcome = mockOutcomeFailed
+		} else if roll < ms.opts.FailRate+ms.opts.MissingRate {
+			outcome = mockOutcomeMissing
+		}
+
+		requests = append(requests, &mockRequest{
+			CustomID: record.CustomID,
+			Model:    record.Body.Model,
+			Prompt:   len([]rune(line))/4 + 1,
+			Outcome:  outcome,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (ms *MockServer) handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	batch, ok := ms.batches[r.PathValue("id")]
+	if !ok {
+		writeMockError(w, http.StatusNotFound, "batch不存在")
+		return
+	}
+	ms.refreshBatch(batch, time.Now())
+	writeJSON(w, http.StatusOK, ms.batchObject(batch))
+}
+
+func (ms *MockServer) handleCancelBatch(w http.ResponseWriter, r *http.Request) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	batch, ok := ms.batches[r.PathValue("id")]
+	if !ok {
+		writeMockError(w, http.StatusNotFound, "batch不存在")
+		return
+	}
+
+	now := time.Now()
+	ms.refreshBatch(batch, now)
+	if batch.OutputFileID == "" {
+		batch.CanceledAt = &now
+		ms.refreshBatch(batch, now)
+	}
+	writeJSON(w, http.StatusOK, ms.batchObject(batch))
+}
+
+// refreshBatch 根据创建时间推进batch状态（调用方持有锁）
+func (ms *MockServer) refreshBatch(batch *mockBatch, now time.Time) {
+	if batch.OutputFileID != "" {
+		return
+	}
+
+	total := len(batch.Requests)
+	if batch.CanceledAt != nil {
+		ms.finalizeBatch(batch, ms.processedCount(batch, *batch.CanceledAt), BatchStatusCanceled, "batch_cancelled")
+		return
+	}
+
+	step := int(now.Sub(batch.CreatedAt) / ms.opts.StepTime)
+	switch {
+	case step <= 0:
+		batch.Status = BatchStatusQueueing
+	case step == 1:
+		batch.Status = BatchStatusInProgress
+		batch.Completed, batch.Failed = ms.countOutcomes(batch.Requests[:ms.processedCount(batch, now)])
+	case step == 2:
+		batch.Status = BatchStatusFinalizing
+		batch.Completed, batch.Failed = ms.countOutcomes(batch.Requests)
+	default:
+		if batch.Expire {
+			ms.finalizeBatch(batch, total/2, BatchStatusExpired, "batch_expired")
+		} else {
+			ms.finalizeBatch(batch, total, BatchStatusCompleted, "")
+		}
+	}
+}
+
+// processedCount 计算 in_progress 阶段已处理的请求数
+func (ms *MockServer) processedCount(batch *mockBatch, now time.Time) int {
+	elapsed := now.Sub(batch.CreatedAt) - ms.opts.StepTime
+	if elapsed <= 0 {
+		return 0
+	}
+	total := len(batch.Requests)
+	processed := int(float64(total) * float64(elapsed) / float64(ms.opts.StepTime))
+	if processed > total {
+		processed = total
+	}
+	return processed
+}
+
+// countOutcomes 统计已完成/失败数（缺失的请求计入已完成，模拟服务端计数与结果文件不一致的情况）
+func (ms *MockServer) countOutcomes(requests []*mockRequest) (int, int) {
+	completed, failed := 0, 0
+	for _, req := range requests {
+		if req.Outcome == mockOutcomeFailed {
+			failed++
+		} else {
+			completed++
+		}
+	}
+	return completed, failed
+}
+
+// finalizeBatch 生成output/error文件并将batch置为结束状态（调用方持有锁）
+// processed 之后的请求统一写入error文件，错误码为 unprocessedCode
+func (ms *MockServer) finalizeBatch(batch *mockBatch, processed int, status BatchStatus, unprocessedCode string) {
+	var output, errorOutput bytes.Buffer
+	completed, failed := 0, 0
+
+	for i, req := range batch.Requests {
+		switch {
+		case i >= processed:
+			failed++
+			writeMockLine(&errorOutput, mockErrorLine(req, http.StatusBadRequest, unprocessedCode, "请求未被处理: "+unprocessedCode))
+		case req.Outcome == mockOutcomeFailed:
+			failed++
+			writeMockLine(&errorOutput, ms.randomErrorLine(req))
+		case req.Outcome == mockOutcomeMissing:
+			completed++
+		default:
+			completed++
+			writeMockLine(&output, mockOutputLine(req))
+		}
+	}
+
+	batch.Status = status
+	batch.Completed = completed
+	batch.Failed = failed
+	batch.OutputFileID = ms.addFile(batch.ID+"_output.jsonl", "batch_output", output.Bytes()).ID
+	if errorOutput.Len() > 0 {
+		batch.ErrorFileID = ms.addFile(batch.ID+"_error.jsonl", "batch_output", errorOutput.Bytes()).ID
+	}
+	logInfo("模拟batch已结束: %s (状态: %s, 完成: %d, 失败: %d)", batch.ID, status, completed, failed)
+}
+
+func writeMockLine(buf *bytes.Buffer, line map[string]interface{}) {
+	data, _ := json.Marshal(line)
+	buf.Write(data)
+	buf.WriteByte('\n')
+}
+
+// mockOutputLine 构造成功结果行
+func mockOutputLine(req *mockRequest) map[string]interface{} {
+	content := fmt.Sprintf("mock response for %s", req.CustomID)
+	completion := len(content) / 4
+	return map[string]interface{}{
+		"id":        "batch_req_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		"custom_id": req.CustomID,
+		"response": map[string]interface{}{
+			"status_code": http.StatusOK,
+			"request_id":  uuid.New().String(),
+			"body": map[string]interface{}{
+				"id":      "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+				"object":  "chat.completion",
+				"created": time.Now().Unix(),
+				"model":   req.Model,
+				"choices": []interface{}{
+					map[string]interface{}{
+						"index": 0,
+						"message": map[string]interface{}{
+							"role":              "assistant",
+							"content":           content,
+							"reasoning_content": "mock reasoning",
+						},
+						"finish_reason": "stop",
+					},
+				},
+				"usage": map[string]interface{}{
+					"prompt_tokens":     req.Prompt,
+					"completion_tokens": completion,
+					"total_tokens":      req.Prompt + completion,
+				},
+			},
+		},
+		"error": nil,
+	}
+}
+
+// mockErrorLine 构造失败结果行
+func mockErrorLine(req *mockRequest, statusCode int, code string, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        "batch_req_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		"custom_id": req.CustomID,
+		"response": map[string]interface{}{
+			"status_code": statusCode,
+			"request_id":  uuid.New().String(),
+			"body": map[string]interface{}{
+				"error": map[string]interface{}{
+					"message": message,
+					"type":    code,
+					"code":    code,
+				},
+			},
+		},
+		"error": nil,
+	}
+}
+
+// randomErrorLine 随机构造一种常见的失败
+func (ms *MockServer) randomErrorLine(req *mockRequest) map[string]interface{} {
+	kinds := []struct {
+		status  int
+		code    string
+		message string
+	}{
+		{http.StatusTooManyRequests, "rate_limit_exceeded", "Rate limit reached, please try again later"},
+		{http.StatusInternalServerError, "server_error", "The server had an error while processing your request"},
+		{http.StatusGatewayTimeout, "timeout", "Request timed out"},
+		{http.StatusBadRequest, "context_length_exceeded", "This model's maximum context length has been exceeded"},
+	}
+	kind := kinds[ms.rand.Intn(len(kinds))]
+	return mockErrorLine(req, kind.status, kind.code, kind.message)
+}
+
+// batchObject 构造batch响应
+func (ms *MockServer) batchObject(batch *mockBatch) map[string]interface{} {
+	obj := map[string]interface{}{
+		"id":                batch.ID,
+		"object":            "batch",
+		"endpoint":          batch.Endpoint,
+		"input_file_id":     batch.InputFileID,
+		"completion_window": batch.Window,
+		"status":            string(batch.Status),
+		"created_at":        batch.CreatedAt.Unix(),
+		"request_counts": map[string]interface{}{
+			"total":     len(batch.Requests),
+			"completed": batch.Completed,
+			"failed":    batch.Failed,
+		},
+	}
+	if batch.OutputFileID != "" {
+		obj["output_file_id"] = batch.OutputFileID
+	}
+	if batch.ErrorFileID != "" {
+		obj["error_file_id"] = batch.ErrorFileID
+	}
+	return obj
+}
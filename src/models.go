@@ -26,6 +26,7 @@ const (
 	FileStatusProcessCompleted FileStatus = "process_completed"
 	FileStatusCanceled         FileStatus = "canceled"
 	FileStatusFailed           FileStatus = "failed"
+	FileStatusPaused           FileStatus = "paused" // 下一轮重试会超出预算，等待 -resume
 )
 
 // BatchStatus 批处理任务状态
@@ -57,6 +58,7 @@ type FileChunk struct {
 	ErrorMessage   *string        `json:"error_message,omitempty"`
 	BatchTaskInfo  *BatchTaskInfo `json:"batch_task_info,omitempty"`
 	Retry          int            `json:"retry"`
+	Usage          TokenUsage     `json:"usage"` // 合并时统计的成功结果 token 用量
 }
 
 // FileInfo 文件信息
@@ -74,7 +76,63 @@ type FileInfo struct {
 	MergedPath       *string      `json:"merged_path,omitempty"`
 	ErrorMessage     *string      `json:"error_message,omitempty"`
 	Retry            int          `json:"retry"`
-	MaxRetry         int          `json:"max_retry"` // 最大重试次数
+	MaxRetry         int          `json:"max_retry"`       // 最大重试次数
+	CachedLines      int          `json:"cached_lines"`    // 命中结果缓存、未提交的行数
+	RejectedLines    int          `json:"rejected_lines"`  // 分割时跳过的行数，明细见 rejected_input.jsonl
+	CustomIDKey      string       `json:"custom_id_key"`   // 分割时使用的 custom_id 字段，为空表示使用行号
+	Compression      string       `json:"compression"`     // 分块文件与合并结果的压缩方式，为空表示不压缩
+	InputFormat      string       `json:"input_format"`    // 原始文件格式：jsonl、csv、parquet
+	Model            string       `json:"model"`           // 分割时使用的模型（domain），用于估算费用
+	Usage            TokenUsage   `json:"usage"`           // 各文件块 token 用量之和，不含命中缓存的结果
+	EstimatedUsage   TokenUsage   `json:"estimated_usage"` // 分割时的预估用量：输入按分词器估算，输出按 max_tokens 计
+}
+
+// TokenUsage token 用量
+type TokenUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	ReasoningTokens  int64 `json:"reasoning_tokens"` // 思考部分，已计入 completion_tokens
+}
+
+// Add 累加另一份用量
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.ReasoningTokens += other.ReasoningTokens
+}
+
+// Record 一条输入记录在某一轮中的状态（records 表的一行），每轮重试新增一行
+type Record struct {
+	TaskID          string  `json:"task_id"`
+	CustomID        string  `json:"custom_id"`
+	SourceLine      int     `json:"source_line"`              // 原始文件中的行号，补齐旧任务时为0
+	ChunkID         *string `json:"chunk_id,omitempty"`       // 所在文件块，命中缓存时为空
+	Retry           int     `json:"retry"`                    // 重试轮次
+	Status          string  `json:"status"`                   // pending、processed、success、cached、failed、terminal、missing
+	ErrorCategory   *string `json:"error_category,omitempty"` // 失败时的错误分类
+	ErrorStatusCode *int    `json:"error_status_code,omitempty"`
+	ErrorCode       *string `json:"error_code,omitempty"`
+	ErrorMessage    *string `json:"error_message,omitempty"`
+	OutputOffset    *int64  `json:"output_offset,omitempty"` // 成功结果在 output_retry<N>.jsonl（解压后）中的字节偏移
+	UpdatedTime     string  `json:"updated_time"`
+}
+
+// ErrorInfo 记录中保存的错误信息，没有错误时各字段为空
+func (r *Record) ErrorInfo() ErrorInfo {
+	var info ErrorInfo
+	if r.ErrorCategory != nil {
+		info.Category = ErrorCategory(*r.ErrorCategory)
+	}
+	if r.ErrorStatusCode != nil {
+		info.StatusCode = *r.ErrorStatusCode
+	}
+	if r.ErrorCode != nil {
+		info.Code = *r.ErrorCode
+	}
+	if r.ErrorMessage != nil {
+		info.Message = *r.ErrorMessage
+	}
+	return info
 }
 
 // BatchTaskInfo 批处理任务信息
@@ -122,6 +180,8 @@ func (f *FileInfo) GetStatusSummary() *StatusSummary {
 	summary.Total["total_count"] = 0
 	summary.Total["complete_count"] = 0
 	summary.Total["failed_count"] = 0
+	summary.Total["cached_count"] = f.CachedLines
+	summary.Total["complete_count"] = f.CachedLines
 
 	if len(f.Chunks) == 0 {
 		return summary
@@ -165,7 +225,7 @@ func (f *FileInfo) GetStatusSummary() *StatusSummary {
 					"complete_count": chunk.BatchTaskInfo.CompletedCount,
 					"failed_count":   chunk.BatchTaskInfo.FailedCount,
 				}
-				logInfo("chunk batch start time:%s", chunk.BatchStartTime)
+				logInfo("chunk batch start time:%v", chunk.BatchStartTime)
 				// 添加 batch_start_time，转换为 int64 (Unix 时间戳)
 				if chunk.BatchStartTime != nil && *chunk.BatchStartTime != "" {
 					trunkInfo["batch_start_time"] = *chunk.BatchStartTime
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 记录在最终结果中的状态
+const (
+	RecordStatusSuccess = "success" // 批处理返回了结果
+	RecordStatusCached  = "cached"  // 分割时命中结果缓存
+	RecordStatusFailed  = "failed"  // error 文件中的失败记录
+	RecordStatusMissing = "missing" // output 与 error 中都没有
+)
+
+// records 表中一轮尚未得到结果的状态，以及不可重试的失败
+const (
+	RecordStatusPending   = "pending"   // 已写入文件块，等待批处理
+	RecordStatusProcessed = "processed" // 批处理结束、结果已下载，等待合并
+	RecordStatusTerminal  = "terminal"  // 不可重试的失败
+)
+
+// recordOutcome 一条提交过的输入记录在最终结果中的情况
+type recordOutcome struct {
+	CustomID   string
+	Status     string
+	Retry      int               // 产生该结果（或最后一次失败）的重试轮次，命中缓存时为 -1
+	Completion *CompletionResult // 成功或命中缓存时非空
+	Error      *ErrorInfo        // 失败或缺失时非空
+}
+
+// roundError 某条记录最后一次失败的轮次与错误
+type roundError struct {
+	retry int
+	info  ErrorInfo
+}
+
+// forEachRecordOutcome 按原始输入顺序遍历实际提交过的记录，依次回调原始记录、原始文本及其最终结果
+func (fm *FileManager) forEachRecordOutcome(fileInfo *FileInfo, fn func(record map[string]interface{}, raw string, outcome recordOutcome) error) error {
+	finalOutputPath := mergedFilePath(fileInfo, "output.jsonl")
+	if _, err := os.Stat(finalOutputPath); err != nil {
+		return fmt.Errorf("最终结果文件不存在: %s", finalOutputPath)
+	}
+
+	// 关联时需要按偏移量随机读取，压缩的结果先解压到临时文件
+	if fileInfo.Compression != CompressionNone {
+		plainPath := filepath.Join(MERGED_DIR, fileInfo.TaskID, ".output.jsonl.join")
+		if _, err := concatLines(plainPath, []string{finalOutputPath}); err != nil {
+			os.Remove(plainPath)
+			return fmt.Errorf("解压最终结果文件失败: %v", err)
+		}
+		defer os.Remove(plainPath)
+		finalOutputPath = plainPath
+	}
+
+	outputFile, err := os.Open(finalOutputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	// 只保存每条结果在output.jsonl中的偏移量，关联时再按偏移量读取，避免把全部结果放进内存
+	outputOffsets, err := indexOutputOffsets(outputFile)
+	if err != nil {
+		return fmt.Errorf("读取最终结果文件失败: %v", err)
+	}
+
+	// 各轮成功结果所在的轮次，不在其中的结果来自缓存
+	outputRetries := make(map[string]int)
+	// 各轮的错误，后一轮覆盖前一轮
+	roundErrors := make(map[string]roundError)
+	for retryLevel := 0; retryLevel <= fileInfo.Retry; retryLevel++ {
+		err := forEachLine(mergedFilePath(fileInfo, fmt.Sprintf("output_retry%d.jsonl", retryLevel)), func(line string) error {
+			outputRetries[lineCustomID(line)] = retryLevel
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("读取结果文件失败: %v", err)
+		}
+
+		err = forEachLine(mergedFilePath(fileInfo, fmt.Sprintf("error_retry%d.jsonl", retryLevel)), func(line string) error {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				return nil
+			}
+			customID, _ := record["custom_id"].(string)
+			roundErrors[customID] = roundError{retry: retryLevel, info: ClassifyErrorLine(record)}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("读取错误文件失败: %v", err)
+		}
+	}
+
+	outputReader := bufio.NewReader(outputFile)
+
+	// 与 SplitFile 相同的遍历方式，只输出实际提交过（或命中缓存）的行，共 TotalLines 行
+	return forEachBatchRequest(fileInfo.FilePath, fileInfo.InputFormat, fileInfo.CustomIDKey, fileInfo.TotalLines, func(request *batchRequest, record map[string]interface{}, lineNumber int, raw string) error {
+		customID := request.CustomID
+
+		outcome := recordOutcome{CustomID: customID}
+		if offset, ok := outputOffsets[customID]; ok {
+			result, err := readResultAt(outputFile, outputReader, offset)
+			if err != nil {
+				return fmt.Errorf("读取custom_id=%s的结果失败: %v", customID, err)
+			}
+			completion := ParseCompletion(result)
+			outcome.Completion = &completion
+			if retry, ok := outputRetries[customID]; ok {
+				outcome.Status = RecordStatusSuccess
+				outcome.Retry = retry
+			} else {
+				outcome.Status = RecordStatusCached
+				outcome.Retry = -1
+			}
+		} else if roundErr, ok := roundErrors[customID]; ok {
+			outcome.Status = RecordStatusFailed
+			outcome.Retry = roundErr.retry
+			outcome.Error = &roundErr.info
+		} else {
+			outcome.Status = RecordStatusMissing
+			outcome.Retry = fileInfo.Retry
+			outcome.Error = &ErrorInfo{Category: "missing", Message: "未获取到该行的结果"}
+		}
+		return fn(record, raw, outcome)
+	})
+}
+
+// JoinOutput 将最终结果按 custom_id 关联回原始输入记录，按输入顺序写入 joined_output.jsonl
+// 成功的行加入 content、reasoning_content、usage、finish_reason，失败或缺失的行加入 error
+func (fm *FileManager) JoinOutput(taskID string) (string, error) {
+	fileInfo, err := fm.dbManager.GetFileHeader(taskID)
+	if err != nil || fileInfo == nil {
+		return "", fmt.Errorf("文件不存在: %s", taskID)
+	}
+
+	joinedPath := mergedFilePath(fileInfo, "joined_output.jsonl")
+	writer, err := createLineWriter(joinedPath)
+	if err != nil {
+		return "", err
+	}
+	defer writer.Close()
+
+	joinedErrors := 0
+	err = fm.forEachRecordOutcome(fileInfo, func(record map[string]interface{}, raw string, outcome recordOutcome) error {
+		var fields []joinField
+		if completion := outcome.Completion; completion != nil {
+			fields = append(fields, joinField{JoinConf.ContentKey, completion.Content})
+			if completion.ReasoningContent != nil {
+				fields = append(fields, joinField{JoinConf.ReasoningKey, completion.ReasoningContent})
+			}
+			if completion.Usage != nil {
+				fields = append(fields, joinField{JoinConf.UsageKey, completion.Usage})
+			}
+			if completion.FinishReason != "" {
+				fields = append(fields, joinField{JoinConf.FinishReasonKey, completion.FinishReason})
+			}
+		} else {
+			fields = append(fields, joinField{JoinConf.ErrorKey, *outcome.Error})
+			joinedErrors++
+		}
+
+		// JSONL、Parquet 的原始文本是 JSON 对象，直接在其后追加结果字段，字段顺序与数值写法保持不变；
+		// CSV 的各列都是字符串，按列名排序输出
+		var line string
+		var err error
+		if fileInfo.InputFormat == InputFormatCSV {
+			for _, field := range fields {
+				record[field.key] = field.value
+			}
+			var data []byte
+			data, err = json.Marshal(record)
+			line = string(data)
+		} else {
+			line, err = appendJSONFields(raw, fields)
+		}
+		if err != nil {
+			return err
+		}
+		return writer.WriteLine(line)
+	})
+	if err != nil {
+		return "", fmt.Errorf("关联原始输入失败: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	logInfo("关联原始输入完成: 共%d行，其中失败%d行，文件: %s", writer.count, joinedErrors, joinedPath)
+	return joinedPath, nil
+}
+
+// joinField 关联时追加到原始记录中的一个结果字段
+type joinField struct {
+	key   string
+	value interface{}
+}
+
+// appendJSONFields 在原始 JSON 对象文本后追加字段：原有字段的顺序与值的原文不变，
+// 与追加字段同名的原有字段被去掉（结果覆盖输入中的同名字段）
+func appendJSONFields(raw string, fields []joinField) (string, error) {
+	replaced := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		replaced[field.key] = true
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return "", fmt.Errorf("原始记录不是 JSON 对象")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	write := func(key string, value []byte) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		keyJSON, _ := json.Marshal(key)
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return "", err
+		}
+		if !replaced[key] {
+			write(key, value)
+		}
+	}
+	for _, field := range fields {
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return "", err
+		}
+		write(field.key, value)
+	}
+	buf.WriteByte('}')
+	return buf.String(), nil
+}
+
+// indexOutputOffsets 记录output.jsonl中每个custom_id所在行的起始偏移量
+func indexOutputOffsets(file *os.File) (map[string]int64, error) {
+	offsets := make(map[string]int64)
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			if customID := lineCustomID(string(data)); customID != "" {
+				if _, ok := offsets[customID]; !ok {
+					offsets[customID] = offset
+				}
+			}
+			offset += int64(len(data))
+		}
+		if err == io.EOF {
+			return offsets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readResultAt 读取指定偏移量处的一行结果
+func readResultAt(file *os.File, reader *bufio.Reader, offset int64) (map[string]interface{}, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader.Reset(file)
+	data, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// readRecordOutput 按 records 表中记录的偏移读取某一轮的成功结果（压缩文件边解压边跳过）
+func readRecordOutput(fileInfo *FileInfo, record *Record) (*CompletionResult, error) {
+	if record.OutputOffset == nil {
+		return nil, fmt.Errorf("没有结果偏移")
+	}
+	file, err := openFile(mergedFilePath(fileInfo, fmt.Sprintf("output_retry%d.jsonl", record.Retry)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if _, err := reader.Discard(int(*record.OutputOffset)); err != nil {
+		return nil, err
+	}
+	data, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if customID, _ := result["custom_id"].(string); customID != record.CustomID {
+		return nil, fmt.Errorf("偏移 %d 处的结果属于 custom_id=%s", *record.OutputOffset, customID)
+	}
+	completion := ParseCompletion(result)
+	return &completion, nil
+}
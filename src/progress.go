@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -186,20 +187,30 @@ func SecondsToHHMMSS(seconds int64) string {
 	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, secs)
 }
 func getRemainTime(execTime *time.Time, completedRadio float64) string {
-	if execTime == nil || completedRadio == 0 {
+	if execTime == nil {
 		return "        "
 	}
 	logInfo("getRemainTime :%s", execTime.Format(time.DateTime))
-	if completedRadio >= 1 {
-		return "00:00:01"
-	}
-	usedTime := time.Now().Sub(*execTime).Seconds()
-	if usedTime < 0 {
+	reaminTime, ok := remainSeconds(*execTime, completedRadio)
+	if !ok {
 		return "        "
 	}
-	reaminTime := int(usedTime/completedRadio - usedTime)
-	return SecondsToHHMMSS(int64(reaminTime))
+	return SecondsToHHMMSS(reaminTime)
+}
 
+// remainSeconds 按开始时间与完成比例估算剩余秒数，尚无进度或开始时间无效时返回 false
+func remainSeconds(startTime time.Time, completedRatio float64) (int64, bool) {
+	if !(completedRatio > 0) {
+		return 0, false
+	}
+	if completedRatio >= 1 {
+		return 1, true
+	}
+	usedTime := time.Now().Sub(startTime).Seconds()
+	if usedTime < 0 {
+		return 0, false
+	}
+	return int64(usedTime/completedRatio - usedTime), true
 }
 
 // ShowStatus 显示文件状态
@@ -207,10 +218,18 @@ func (p *ProgressDisplay) ShowStatus(fileInfo *FileInfo, clearScreen bool) {
 	summary := fileInfo.GetStatusSummary()
 	total := summary.Total
 
-	statusMsg := fmt.Sprintf("\n 文件: %s | task_id: %s \n 总块数: %d | 待上传：%d | 已上传: %d | 处理中: %d | 已处理: %d | 上传失败: %d \n 总处理行数: %d | 已完成行数: %d | 失败行数: %d | 重试次数: %d次",
+	statusMsg := fmt.Sprintf("\n 文件: %s | task_id: %s \n 总块数: %d | 待上传：%d | 已上传: %d | 处理中: %d | 已处理: %d | 上传失败: %d \n 总处理行数: %d | 已完成行数: %d | 失败行数: %d | 命中缓存: %d | 重试次数: %d次",
 		fileInfo.OriginalFilename, fileInfo.TaskID, summary.TotalChunks,
 		total["pending"], total["uploaded"], total["processing"], total["processed"], total["upload_failed"],
-		fileInfo.TotalLines, total["complete_count"], total["failed_count"], fileInfo.Retry)
+		fileInfo.TotalLines, total["complete_count"], total["failed_count"], total["cached_count"], fileInfo.Retry)
+
+	statusMsg += "\n " + formatUsage(fileInfo, fileInfo.Usage)
+	if fileInfo.RejectedLines > 0 {
+		statusMsg += fmt.Sprintf("\n 分割时跳过 %d 行，明细见 %s", fileInfo.RejectedLines, rejectedInputPath(fileInfo.TaskID))
+	}
+	if fileInfo.Status == FileStatusPaused && fileInfo.ErrorMessage != nil {
+		statusMsg += "\n 已暂停: " + *fileInfo.ErrorMessage
+	}
 
 	// 计算进度条
 	totalCount := fileInfo.TotalLines
@@ -256,6 +275,97 @@ func (p *ProgressDisplay) ShowStatus(fileInfo *FileInfo, clearScreen bool) {
 	p.Update(statusMsg)
 }
 
+// ShowUsage 显示任务各文件块及合计的 token 用量和预估费用
+func (p *ProgressDisplay) ShowUsage(fileInfo *FileInfo) {
+	statusMsg := fmt.Sprintf("\n 文件: %s | task_id: %s | 模型: %s", fileInfo.OriginalFilename, fileInfo.TaskID, usageModel(fileInfo))
+	for _, chunk := range fileInfo.Chunks {
+		statusMsg += fmt.Sprintf("\n %s (retry%d): %s", chunk.ChunkID, chunk.Retry, formatUsage(fileInfo, chunk.Usage))
+	}
+	statusMsg += "\n 合计: " + formatUsage(fileInfo, fileInfo.Usage)
+	statusMsg += "\n 分割时预估（输出按 max_tokens 计）: " + formatUsage(fileInfo, fileInfo.EstimatedUsage)
+	if fileInfo.CachedLines > 0 {
+		statusMsg += fmt.Sprintf("\n 命中缓存 %d 行，未计入用量", fileInfo.CachedLines)
+	}
+	p.Update(statusMsg)
+}
+
+// usageModel 估算费用使用的模型，旧任务未记录模型时使用当前配置
+func usageModel(fileInfo *FileInfo) string {
+	if fileInfo.Model != "" {
+		return fileInfo.Model
+	}
+	return ModelConf.Domain
+}
+
+// formatUsage 格式化 token 用量，配置了模型单价时附带预估费用
+func formatUsage(fileInfo *FileInfo, usage TokenUsage) string {
+	text := fmt.Sprintf("输入token: %d | 输出token: %d（思考: %d）", usage.PromptTokens, usage.CompletionTokens, usage.ReasoningTokens)
+	pricing, _ := currentBudget()
+	if cost, ok := pricing.EstimateCost(usageModel(fileInfo), usage); ok {
+		text += fmt.Sprintf(" | 预估费用: %.4f %s", cost, pricing.Currency)
+	}
+	return text
+}
+
+// ShowRecordHistory 显示一条记录在各轮中的状态，成功时附带结果内容
+func (p *ProgressDisplay) ShowRecordHistory(fileInfo *FileInfo, records []*Record) {
+	statusMsg := fmt.Sprintf("\n task_id: %s | custom_id: %s | 原始行号: %d", fileInfo.TaskID, records[0].CustomID, records[0].SourceLine)
+	for _, record := range records {
+		statusMsg += "\n " + formatRecord(record)
+		if record.OutputOffset == nil {
+			continue
+		}
+		completion, err := readRecordOutput(fileInfo, record)
+		if err != nil {
+			statusMsg += fmt.Sprintf("\n   读取结果失败: %v", err)
+			continue
+		}
+		content, _ := json.Marshal(completion.Content)
+		statusMsg += fmt.Sprintf("\n   结果（finish_reason: %s）: %s", completion.FinishReason, truncateText(string(content), 500))
+	}
+	p.Update(statusMsg)
+}
+
+// ShowRecordSummary 显示任务各记录最新一轮的状态统计，以及未成功的记录
+func (p *ProgressDisplay) ShowRecordSummary(fileInfo *FileInfo, counts map[string]int, unfinished []*Record, limit int) {
+	statusMsg := fmt.Sprintf("\n 文件: %s | task_id: %s | 重试次数: %d次", fileInfo.OriginalFilename, fileInfo.TaskID, fileInfo.Retry)
+	statusMsg += fmt.Sprintf("\n 成功: %d | 命中缓存: %d | 等待中: %d | 失败待重试: %d | 不可重试: %d | 缺失: %d",
+		counts[RecordStatusSuccess], counts[RecordStatusCached], counts[RecordStatusPending]+counts[RecordStatusProcessed],
+		counts[RecordStatusFailed], counts[RecordStatusTerminal], counts[RecordStatusMissing])
+	if len(unfinished) > 0 {
+		statusMsg += fmt.Sprintf("\n 未成功的记录（按原始行号，最多 %d 条）:", limit)
+		for _, record := range unfinished {
+			statusMsg += fmt.Sprintf("\n 第%d行 custom_id=%s | %s", record.SourceLine, record.CustomID, formatRecord(record))
+		}
+	}
+	p.Update(statusMsg)
+}
+
+// formatRecord 一轮状态的单行描述
+func formatRecord(record *Record) string {
+	chunkID := "-"
+	if record.ChunkID != nil {
+		chunkID = *record.ChunkID
+	}
+	text := fmt.Sprintf("第%d轮 | %s | chunk: %s | 更新时间: %s", record.Retry, record.Status, chunkID, record.UpdatedTime)
+	if record.ErrorCategory != nil {
+		text += " | " + *record.ErrorCategory
+		if record.ErrorMessage != nil && *record.ErrorMessage != "" {
+			text += ": " + truncateText(*record.ErrorMessage, 200)
+		}
+	}
+	return text
+}
+
+// truncateText 截断过长的文本（按字符）
+func truncateText(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes]) + "..."
+}
+
 // ShowSimpleFileInfo 显示简化的文件信息（只显示文件名、file_id、创建时间、当前状态）
 func (p *ProgressDisplay) ShowSimpleFileInfo(fileInfo *FileInfo) {
 	statusMsg := fmt.Sprintf("文件名: %s | task_id: %s | 创建时间: %s | 当前状态: %s",
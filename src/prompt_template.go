@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// PromptTemplateConfig 由输入记录的字段渲染 messages 的模板配置（Go text/template 语法）
+type PromptTemplateConfig struct {
+	System    string `yaml:"system"`    // system 提示词，为空时不添加
+	User      string `yaml:"user"`      // user 消息模板，如 "{{.question}}"
+	Assistant string `yaml:"assistant"` // assistant 消息模板（预填充回复开头），为空时不添加
+}
+
+// PromptTemplate 解析后的模板
+type PromptTemplate struct {
+	system    *template.Template
+	user      *template.Template
+	assistant *template.Template
+}
+
+// promptTemplateFuncs 模板中可用的函数
+var promptTemplateFuncs = template.FuncMap{
+	// json 将字段序列化为 JSON 字符串，用于嵌入对象或数组字段
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// NewPromptTemplate 解析模板配置，引用输入记录中不存在的字段时渲染报错
+func NewPromptTemplate(conf PromptTemplateConfig) (*PromptTemplate, error) {
+	if conf.User == "" {
+		return nil, fmt.Errorf("user 模板不能为空")
+	}
+
+	parse := func(name, text string) (*template.Template, error) {
+		if text == "" {
+			return nil, nil
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Funcs(promptTemplateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s 模板解析失败: %v", name, err)
+		}
+		return tmpl, nil
+	}
+
+	pt := &PromptTemplate{}
+	var err error
+	if pt.system, err = parse("system", conf.System); err != nil {
+		return nil, err
+	}
+	if pt.user, err = parse("user", conf.User); err != nil {
+		return nil, err
+	}
+	if pt.assistant, err = parse("assistant", conf.Assistant); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+// Render 使用输入记录渲染 messages
+func (pt *PromptTemplate) Render(record map[string]interface{}) ([]interface{}, error) {
+	messages := []interface{}{}
+	for _, part := range []struct {
+		role string
+		tmpl *template.Template
+	}{
+		{"system", pt.system},
+		{"user", pt.user},
+		{"assistant", pt.assistant},
+	} {
+		if part.tmpl == nil {
+			continue
+		}
+		var sb strings.Builder
+		if err := part.tmpl.Execute(&sb, record); err != nil {
+			return nil, fmt.Errorf("渲染 %s 模板失败: %v", part.role, err)
+		}
+		messages = append(messages, map[string]interface{}{
+			"role":    part.role,
+			"content": sb.String(),
+		})
+	}
+	return messages, nil
+}
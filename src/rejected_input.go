@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// RejectedLine 分割时被跳过的输入行
+type RejectedLine struct {
+	Line   int    `json:"line"`   // 原始文件中的行号（CSV、Parquet为数据行序号）
+	Reason string `json:"reason"` // 跳过原因
+	Raw    string `json:"raw"`    // 原始文本
+}
+
+// rejectedInputPath 任务中被跳过的输入行文件
+func rejectedInputPath(taskID string) string {
+	return filepath.Join(MERGED_DIR, taskID, "rejected_input.jsonl")
+}
+
+// rejectedInputWriter 分割文件时将跳过的输入行写入任务的 rejected_input.jsonl
+type rejectedInputWriter struct {
+	path   string
+	file   *os.File
+	writer *bufio.Writer
+	count  int
+}
+
+// newRejectedInputWriter 创建跳过行写入器，文件在第一次写入时才创建
+func newRejectedInputWriter(taskID string) *rejectedInputWriter {
+	return &rejectedInputWriter{path: rejectedInputPath(taskID)}
+}
+
+// Write 记录一条被跳过的输入行
+func (w *rejectedInputWriter) Write(line int, reason string, raw string) error {
+	w.count++
+	if w.writer == nil {
+		if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(w.path)
+		if err != nil {
+			return err
+		}
+		w.file = file
+		w.writer = bufio.NewWriter(file)
+	}
+
+	data, err := json.Marshal(RejectedLine{Line: line, Reason: reason, Raw: raw})
+	if err != nil {
+		return err
+	}
+	w.writer.Write(data)
+	return w.writer.WriteByte('\n')
+}
+
+// Close 刷新并关闭文件
+func (w *rejectedInputWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+	writer, file := w.writer, w.file
+	w.writer, w.file = nil, nil
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
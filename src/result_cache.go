@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ResultCache 按请求内容哈希保存模型结果的本地缓存（内容寻址目录：<dir>/<hash前两位>/<hash>.json）
+type ResultCache struct {
+	dir string
+}
+
+// NewResultCache 创建结果缓存
+func NewResultCache(dir string) *ResultCache {
+	return &ResultCache{dir: dir}
+}
+
+// requestHash 计算请求的哈希：provider + base_url + body/params（包含 model、messages、max_tokens、temperature、extra_body 等），
+// 不包含 custom_id，因此不同任务、不同行号的相同请求会命中同一条缓存；
+// 同名模型在不同的兼容网关上可能是不同的模型，base_url 不同时不共用缓存
+func requestHash(requestLine map[string]interface{}) string {
+	payload := requestLine["body"]
+	if payload == nil {
+		payload = requestLine["params"]
+	}
+	// encoding/json 对 map 按 key 排序输出，序列化结果是确定的
+	data, _ := json.Marshal(map[string]interface{}{
+		"provider": ModelConf.Provider,
+		"base_url": strings.TrimRight(ModelConf.BaseURL, "/"),
+		"request":  payload,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// path 缓存文件路径
+func (rc *ResultCache) path(hash string) string {
+	return filepath.Join(rc.dir, hash[:2], hash+".json")
+}
+
+// Get 查询缓存，命中时返回缓存的结果行原文（不重新序列化，大整数等数值保持原样）
+func (rc *ResultCache) Get(hash string) (string, bool) {
+	data, err := os.ReadFile(rc.path(hash))
+	if err != nil {
+		return "", false
+	}
+	line := strings.TrimSpace(string(data))
+	if !json.Valid([]byte(line)) {
+		return "", false
+	}
+	return line, true
+}
+
+// Put 保存结果行（先写临时文件再重命名，避免并发读到半个文件）
+func (rc *ResultCache) Put(hash string, line string) error {
+	path := rc.path(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(line); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cachedOutputPath 任务中命中缓存的结果文件（与各chunk的output文件放在一起），与其他中间文件一样按任务的压缩方式压缩
+func cachedOutputPath(fileInfo *FileInfo) string {
+	return withCompressionExt(filepath.Join(BATCH_RESULT_DIR, fileInfo.TaskID, "output", "cached.jsonl"), fileInfo.Compression)
+}
+
+// cachedResultWriter 分割文件时将命中缓存的结果写入任务的 cached.jsonl
+type cachedResultWriter struct {
+	cache  *ResultCache
+	path   string
+	writer *lineWriter
+	count  int
+}
+
+// newCachedResultWriter 创建缓存结果写入器，文件在第一次命中时才创建
+func newCachedResultWriter(cache *ResultCache, fileInfo *FileInfo) *cachedResultWriter {
+	return &cachedResultWriter{cache: cache, path: cachedOutputPath(fileInfo)}
+}
+
+// TryWrite 查询请求是否命中缓存，命中时将缓存结果（custom_id 替换为当前请求的）写入文件
+func (w *cachedResultWriter) TryWrite(requestLine map[string]interface{}) (bool, error) {
+	cached, ok := w.cache.Get(requestHash(requestLine))
+	if !ok {
+		return false, nil
+	}
+	line, err := appendJSONFields(cached, []joinField{
+		{key: "custom_id", value: requestLine["custom_id"]},
+		{key: "cached", value: true},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if w.writer == nil {
+		if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+			return false, err
+		}
+		writer, err := createLineWriter(w.path)
+		if err != nil {
+			return false, err
+		}
+		w.writer = writer
+	}
+	if err := w.writer.WriteLine(line); err != nil {
+		return false, err
+	}
+	w.count++
+	return true, nil
+}
+
+// Close 刷新并关闭文件
+func (w *cachedResultWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+	return w.writer.Close()
+}
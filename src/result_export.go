@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// 支持的导出格式
+const (
+	ExportFormatCSV     = "csv"
+	ExportFormatParquet = "parquet"
+)
+
+// validateExportFormats 校验配置中的导出格式
+func validateExportFormats(formats []string) error {
+	for _, format := range formats {
+		switch format {
+		case ExportFormatCSV, ExportFormatParquet:
+		default:
+			return fmt.Errorf("不支持的导出格式: %s（可选 csv、parquet）", format)
+		}
+	}
+	return nil
+}
+
+// exportColumn 扁平表格中的一列，isInt 为 false 时为字符串列，所有列都可以为空
+type exportColumn struct {
+	name  string
+	isInt bool
+}
+
+// exportColumns 表格的列：custom_id、选择的输入字段、模型输出、token 用量、状态与错误信息
+func exportColumns(inputColumns []string) ([]exportColumn, error) {
+	columns := []exportColumn{{name: "custom_id"}}
+	for _, name := range inputColumns {
+		columns = append(columns, exportColumn{name: name})
+	}
+	columns = append(columns,
+		exportColumn{name: "content"},
+		exportColumn{name: "reasoning_content"},
+		exportColumn{name: "prompt_tokens", isInt: true},
+		exportColumn{name: "completion_tokens", isInt: true},
+		exportColumn{name: "finish_reason"},
+		exportColumn{name: "status"},
+		exportColumn{name: "error_category"},
+		exportColumn{name: "error_message"},
+		exportColumn{name: "retry", isInt: true},
+	)
+
+	seen := make(map[string]bool)
+	for _, column := range columns {
+		if seen[column.name] {
+			return nil, fmt.Errorf("导出列名重复: %s", column.name)
+		}
+		seen[column.name] = true
+	}
+	return columns, nil
+}
+
+// exportRow 将一条记录及其结果转换为与 exportColumns 对应的一行，nil 表示空值
+func exportRow(record map[string]interface{}, outcome recordOutcome, inputColumns []string) []interface{} {
+	row := []interface{}{outcome.CustomID}
+	for _, name := range inputColumns {
+		row = append(row, tableText(record[name]))
+	}
+
+	var content, reasoning, promptTokens, completionTokens, finishReason interface{}
+	if completion := outcome.Completion; completion != nil {
+		content = tableText(completion.Content)
+		reasoning = tableText(completion.ReasoningContent)
+		promptTokens = usageTokens(completion.Usage, "prompt_tokens")
+		completionTokens = usageTokens(completion.Usage, "completion_tokens")
+		if completion.FinishReason != "" {
+			finishReason = completion.FinishReason
+		}
+	}
+
+	var errorCategory, errorMessage interface{}
+	if outcome.Error != nil {
+		errorCategory = string(outcome.Error.Category)
+		errorMessage = outcome.Error.Message
+	}
+
+	var retry interface{}
+	if outcome.Retry >= 0 {
+		retry = int64(outcome.Retry)
+	}
+
+	return append(row, content, reasoning, promptTokens, completionTokens, finishReason,
+		outcome.Status, errorCategory, errorMessage, retry)
+}
+
+// tableText 单元格文本：字符串与数字原样输出（数字保留输入中的写法），其他类型序列化为 JSON
+func tableText(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// usageTokens 读取 usage 中的 token 数，不存在时为空
+func usageTokens(usage map[string]interface{}, key string) interface{} {
+	if num, ok := usage[key].(float64); ok {
+		return int64(num)
+	}
+	return nil
+}
+
+// tableWriter 逐行写入扁平表格
+type tableWriter interface {
+	WriteRow(row []interface{}) error
+	Close() error
+}
+
+// csvTableWriter 带表头的 CSV，开头写入 BOM 以便 Excel 正确识别 UTF-8
+type csvTableWriter struct {
+	file   io.WriteCloser
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVTableWriter(path string, columns []exportColumn) (*csvTableWriter, error) {
+	file, err := createFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(file, "\ufeff"); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &csvTableWriter{file: file, writer: writer, record: make([]string, len(columns))}, nil
+}
+
+func (w *csvTableWriter) WriteRow(row []interface{}) error {
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+			w.record[i] = ""
+		case int64:
+			w.record[i] = strconv.FormatInt(v, 10)
+		default:
+			w.record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.writer.Write(w.record)
+}
+
+func (w *csvTableWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// orderedGroup 按给定顺序排列字段的 parquet.Group（parquet.Group 默认按列名排序）
+type orderedGroup struct {
+	parquet.Group
+	names []string
+}
+
+func (g orderedGroup) Fields() []parquet.Field {
+	fields := make(map[string]parquet.Field, len(g.Group))
+	for _, field := range g.Group.Fields() {
+		fields[field.Name()] = field
+	}
+	ordered := make([]parquet.Field, len(g.names))
+	for i, name := range g.names {
+		ordered[i] = fields[name]
+	}
+	return ordered
+}
+
+// parquetTableWriter 扁平表格的 Parquet 输出，所有列均为 optional，使用 snappy 压缩
+type parquetTableWriter struct {
+	file   *os.File
+	writer *parquet.Writer
+	rows   []parquet.Row
+}
+
+func newParquetTableWriter(path string, columns []exportColumn) (*parquetTableWriter, error) {
+	group := orderedGroup{Group: parquet.Group{}}
+	for _, column := range columns {
+		node := parquet.String()
+		if column.isInt {
+			node = parquet.Int(64)
+		}
+		group.Group[column.name] = parquet.Optional(node)
+		group.names = append(group.names, column.name)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	schema := parquet.NewSchema("output", group)
+	writer := parquet.NewWriter(file, schema, parquet.Compression(&parquet.Snappy))
+	return &parquetTableWriter{file: file, writer: writer}, nil
+}
+
+// parquetTableBatchRows 每累积多少行写入一次
+const parquetTableBatchRows = 1024
+
+func (w *parquetTableWriter) WriteRow(row []interface{}) error {
+	values := make(parquet.Row, len(row))
+	for i, value := range row {
+		if value == nil {
+			values[i] = parquet.Value{}.Level(0, 0, i)
+		} else {
+			values[i] = parquet.ValueOf(value).Level(0, 1, i)
+		}
+	}
+	w.rows = append(w.rows, values)
+	if len(w.rows) >= parquetTableBatchRows {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *parquetTableWriter) flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	_, err := w.writer.WriteRows(w.rows)
+	w.rows = w.rows[:0]
+	return err
+}
+
+func (w *parquetTableWriter) Close() error {
+	if err := w.flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// ExportTable 按原始输入顺序将最终结果导出为扁平表格，每种格式一个文件，返回格式到文件路径的映射
+// CSV 跟随任务的压缩方式，Parquet 使用内部压缩
+func (fm *FileManager) ExportTable(taskID string, formats []string) (map[string]string, error) {
+	fileInfo, err := fm.dbManager.GetFileHeader(taskID)
+	if err != nil || fileInfo == nil {
+		return nil, fmt.Errorf("文件不存在: %s", taskID)
+	}
+	if err := validateExportFormats(formats); err != nil {
+		return nil, err
+	}
+	columns, err := exportColumns(ExportConf.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string)
+	writers := []tableWriter{}
+	defer func() {
+		for _, writer := range writers {
+			writer.Close()
+		}
+	}()
+	for _, format := range formats {
+		if _, ok := paths[format]; ok {
+			continue
+		}
+		var writer tableWriter
+		switch format {
+		case ExportFormatCSV:
+			paths[format] = mergedFilePath(fileInfo, "output_table.csv")
+			writer, err = newCSVTableWriter(paths[format], columns)
+		case ExportFormatParquet:
+			paths[format] = filepath.Join(MERGED_DIR, taskID, "output_table.parquet")
+			writer, err = newParquetTableWriter(paths[format], columns)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("创建%s文件失败: %v", format, err)
+		}
+		writers = append(writers, writer)
+	}
+
+	rows := 0
+	err = fm.forEachRecordOutcome(fileInfo, func(record map[string]interface{}, _ string, outcome recordOutcome) error {
+		row := exportRow(record, outcome, ExportConf.Columns)
+		for _, writer := range writers {
+			if err := writer.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("导出表格失败: %v", err)
+	}
+
+	pending := writers
+	writers = nil
+	for _, writer := range pending {
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("写入表格失败: %v", err)
+		}
+	}
+
+	logInfo("导出表格完成: 共%d行，文件: %v", rows, paths)
+	return paths, nil
+}
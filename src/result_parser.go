@@ -0,0 +1,64 @@
+package main
+
+// CompletionResult 从一行batch结果中解析出的模型输出
+type CompletionResult struct {
+	Content          interface{}            `json:"content"`
+	ReasoningContent interface{}            `json:"reasoning_content,omitempty"`
+	FinishReason     string                 `json:"finish_reason,omitempty"`
+	Usage            map[string]interface{} `json:"usage,omitempty"`
+}
+
+// ParseCompletion 解析 response.body.choices[0].message 与 response.body.usage
+func ParseCompletion(record map[string]interface{}) CompletionResult {
+	result := CompletionResult{}
+
+	response, _ := record["response"].(map[string]interface{})
+	body, _ := response["body"].(map[string]interface{})
+	if body == nil {
+		return result
+	}
+
+	if usage, ok := body["usage"].(map[string]interface{}); ok {
+		result.Usage = usage
+	}
+
+	choices, _ := body["choices"].([]interface{})
+	if len(choices) == 0 {
+		return result
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	if choice == nil {
+		return result
+	}
+	result.FinishReason, _ = choice["finish_reason"].(string)
+
+	if message, ok := choice["message"].(map[string]interface{}); ok {
+		result.Content = message["content"]
+		result.ReasoningContent = message["reasoning_content"]
+	}
+	return result
+}
+
+// ParseUsage 解析 usage 中的 prompt_tokens、completion_tokens 与思考 token 数
+// 思考 token 优先取 completion_tokens_details.reasoning_tokens，其次取顶层的 reasoning_tokens
+func ParseUsage(usage map[string]interface{}) TokenUsage {
+	result := TokenUsage{}
+	if usage == nil {
+		return result
+	}
+
+	prompt, _ := usage["prompt_tokens"].(float64)
+	completion, _ := usage["completion_tokens"].(float64)
+	result.PromptTokens = int64(prompt)
+	result.CompletionTokens = int64(completion)
+
+	if details, ok := usage["completion_tokens_details"].(map[string]interface{}); ok {
+		if reasoning, ok := details["reasoning_tokens"].(float64); ok {
+			result.ReasoningTokens = int64(reasoning)
+			return result
+		}
+	}
+	reasoning, _ := usage["reasoning_tokens"].(float64)
+	result.ReasoningTokens = int64(reasoning)
+	return result
+}
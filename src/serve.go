@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUploadMemory 上传表单在内存中缓存的大小，超出部分写入临时文件
+const maxUploadMemory = 32 << 20
+
+// defaultMaxUploadBytes 未配置 serve.max_upload_bytes 时上传请求体的最大字节数
+const defaultMaxUploadBytes = 10 << 30
+
+// TaskFile 任务结果目录 merged/<task_id>/ 中的文件
+type TaskFile struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	ModifiedTime string `json:"modified_time"`
+}
+
+// Serve 以前台守护进程的方式运行（-serve），除控制接口外在 addr 上提供 REST 接口：
+// /api/ 下为任务的提交（服务端路径或上传）、查询、取消、暂停、继续，以及结果文件的列表与下载
+func (bis *BatchInferService) Serve(addr string) {
+	if bis.checkDaemonRunning() {
+		logError("守护进程已在后台运行，请先执行 -stop-daemon 停止后再启动 -serve")
+		os.Exit(1)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logError("监听 %s 失败: %v", addr, err)
+		os.Exit(1)
+	}
+	if ServeConf.Token == "" {
+		// 不鉴权时只允许本机访问
+		if tcpAddr, ok := listener.Addr().(*net.TCPAddr); !ok || !tcpAddr.IP.IsLoopback() {
+			listener.Close()
+			logError("未配置 serve.token 时只能监听本机回环地址（如 127.0.0.1:8080），当前: %s", listener.Addr())
+			os.Exit(1)
+		}
+		logInfo("警告: 未配置 serve.token，REST 接口不做鉴权，只有本机可以访问")
+	}
+	if len(ServeConf.InputDirs) == 0 {
+		logInfo("未配置 serve.input_dirs，只能上传输入文件提交任务")
+	}
+	logInfo("REST 接口已启动: http://%s/api/tasks，网页看板: http://%s/", listener.Addr(), listener.Addr())
+
+	bis.runDaemonProcess(&http.Server{
+		Handler:           bis.newServeHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}, listener)
+}
+
+// newServeHandler 创建 -serve 的路由：/api/ 下复用控制接口的任务路由并增加结果文件下载，/ 为网页看板
+func (bis *BatchInferService) newServeHandler() http.Handler {
+	api := &controlAPI{bis: bis, serve: true}
+	routes := api.routes()
+	routes.HandleFunc("GET /tasks/{id}/output", api.downloadOutput)
+	routes.HandleFunc("GET /tasks/{id}/files", api.listTaskFiles)
+	routes.HandleFunc("GET /tasks/{id}/files/{name}", api.downloadTaskFile)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", requireBearerToken(ServeConf.Token, routes)))
+	mux.Handle("/", dashboardHandler())
+	return mux
+}
+
+// requireBearerToken 配置了 serve.token 时校验 Authorization: Bearer <token>；
+// 浏览器中的下载链接无法携带请求头，GET 请求也可以使用 ?token= 传递
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" && r.Method == http.MethodGet {
+			got = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeControlErrorStatus(w, http.StatusUnauthorized, errors.New("访问令牌无效"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolveServeInputPath 校验 -serve 中通过 file_path 提交的服务器文件：解析符号链接后必须位于 serve.input_dirs 中，
+// 返回解析后的路径；未配置 serve.input_dirs 时不接受服务器路径
+func resolveServeInputPath(path string) (string, error) {
+	if len(ServeConf.InputDirs) == 0 {
+		return "", fmt.Errorf("%w: 未配置 serve.input_dirs，不接受服务器上的 file_path，请上传文件", errForbidden)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: file_path 需要是绝对路径: %s", errInvalidRequest, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: 文件不存在: %s", errInvalidRequest, path)
+	}
+	for _, dir := range ServeConf.InputDirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: file_path 不在 serve.input_dirs 中: %s", errForbidden, path)
+}
+
+// servableTaskFile 结果目录中可以通过 -serve 下载的文件：rejected_input.jsonl 包含输入行原文，默认不提供
+func servableTaskFile(name string) bool {
+	return ServeConf.ExposeRejectedInput || !strings.HasPrefix(name, "rejected_input.")
+}
+
+// saveUpload 将 multipart 表单中上传的输入文件保存到 uploads/<task_id>_<随机后缀>/，返回提交参数与原始文件名；
+// 请求体超过 serve.max_upload_bytes 时返回 errTooLarge
+func (bis *BatchInferService) saveUpload(w http.ResponseWriter, r *http.Request) (SubmitRequest, string, error) {
+	var req SubmitRequest
+	r.Body = http.MaxBytesReader(w, r.Body, ServeConf.MaxUploadBytes)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return req, "", fmt.Errorf("%w: 上传文件超过 serve.max_upload_bytes（%d 字节）", errTooLarge, maxBytesErr.Limit)
+		}
+		return req, "", fmt.Errorf("%w: 解析上传表单失败: %v", errInvalidRequest, err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	req.TaskID = r.FormValue("task_id")
+	req.InputFormat = r.FormValue("input_format")
+	if value := r.FormValue("lines_per_chunk"); value != "" {
+		lines, err := strconv.Atoi(value)
+		if err != nil {
+			return req, "", fmt.Errorf("%w: lines_per_chunk 需要是整数: %s", errInvalidRequest, value)
+		}
+		req.LinesPerChunk = &lines
+	}
+	if err := validateTaskID(req.TaskID); err != nil {
+		return req, "", err
+	}
+	// 先检查任务是否存在，避免覆盖已有任务的输入文件
+	existing, err := bis.dbManager.GetFileHeader(req.TaskID)
+	if err != nil {
+		return req, "", err
+	}
+	if existing != nil {
+		return req, "", fmt.Errorf("%w: 任务已存在: %s", errTaskState, req.TaskID)
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return req, "", fmt.Errorf("%w: 缺少上传文件 file: %v", errInvalidRequest, err)
+	}
+	defer file.Close()
+
+	// 保留原始文件名（扩展名用于判断输入格式与压缩方式）
+	originalFilename := filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+	if originalFilename == "." || originalFilename == "/" {
+		return req, "", fmt.Errorf("%w: 上传文件名无效: %s", errInvalidRequest, header.Filename)
+	}
+	uploadRoot, err := filepath.Abs(UPLOAD_DIR)
+	if err != nil {
+		return req, "", err
+	}
+	if err := os.MkdirAll(uploadRoot, 0755); err != nil {
+		return req, "", fmt.Errorf("创建上传目录失败: %v", err)
+	}
+	// 每次上传使用单独的目录，同一 task_id 的并发上传不会删除或覆盖对方的文件
+	uploadDir, err := os.MkdirTemp(uploadRoot, req.TaskID+"_")
+	if err != nil {
+		return req, "", fmt.Errorf("创建上传目录失败: %v", err)
+	}
+
+	req.FilePath = filepath.Join(uploadDir, originalFilename)
+	dst, err := os.Create(req.FilePath)
+	if err != nil {
+		return req, "", fmt.Errorf("保存上传文件失败: %v", err)
+	}
+	written, err := io.Copy(dst, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(uploadDir)
+		return req, "", fmt.Errorf("保存上传文件失败: %v", err)
+	}
+	logInfo("[%s] 已保存上传文件: %s (%d 字节)", req.TaskID, req.FilePath, written)
+	return req, originalFilename, nil
+}
+
+// downloadOutput 下载最终结果 output.jsonl（按任务的压缩方式可能为 .gz / .zst），任务尚未完成最终合并时返回 409
+func (api *controlAPI) downloadOutput(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := api.bis.loadTaskHeader(r.PathValue("id"))
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	outputPath := mergedFilePath(fileInfo, "output.jsonl")
+	if _, err := os.Stat(outputPath); err != nil {
+		writeControlError(w, fmt.Errorf("%w: 最终结果尚未生成，当前状态: %s", errTaskState, fileInfo.Status))
+		return
+	}
+	serveTaskFile(w, r, fileInfo.TaskID, outputPath)
+}
+
+// listTaskFiles 列出任务结果目录中的文件（各轮 output/error、失败与缺失记录、joined_output、导出表格等）
+func (api *controlAPI) listTaskFiles(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := api.bis.loadTaskHeader(r.PathValue("id"))
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Join(MERGED_DIR, fileInfo.TaskID))
+	if err != nil && !os.IsNotExist(err) {
+		writeControlError(w, err)
+		return
+	}
+	files := make([]TaskFile, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !servableTaskFile(entry.Name()) {
+			continue
+		}
+		files = append(files, TaskFile{
+			Name:         entry.Name(),
+			Size:         info.Size(),
+			ModifiedTime: info.ModTime().Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// downloadTaskFile 下载任务结果目录中的单个文件，name 只能是文件名
+func (api *controlAPI) downloadTaskFile(w http.ResponseWriter, r *http.Request) {
+	fileInfo, err := api.bis.loadTaskHeader(r.PathValue("id"))
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+	name := r.PathValue("name")
+	if name != filepath.Base(name) || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		writeControlErrorStatus(w, http.StatusBadRequest, fmt.Errorf("%w: 文件名无效: %s", errInvalidRequest, name))
+		return
+	}
+	path := filepath.Join(MERGED_DIR, fileInfo.TaskID, name)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() || !servableTaskFile(name) {
+		writeControlErrorStatus(w, http.StatusNotFound, fmt.Errorf("文件不存在: %s", name))
+		return
+	}
+	serveTaskFile(w, r, fileInfo.TaskID, path)
+}
+
+// serveTaskFile 以附件形式流式返回文件（支持 Range 断点续传），下载名为 <task_id>_<文件名>
+func serveTaskFile(w http.ResponseWriter, r *http.Request, taskID string, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		writeControlErrorStatus(w, http.StatusNotFound, fmt.Errorf("打开文件失败: %v", err))
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeControlError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", taskID+"_"+filepath.Base(path)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), file)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// 支持的分词器
+const (
+	TokenizerCharRatio = "char_ratio"
+	TokenizerBPE       = "bpe"
+)
+
+// Tokenizer 估算文本的 token 数
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// NewTokenizer 按配置创建分词器，未配置时使用字符比例估算
+func NewTokenizer(conf TokenizerConfig) (Tokenizer, error) {
+	switch conf.Type {
+	case "", TokenizerCharRatio:
+		charsPerToken := conf.CharsPerToken
+		if charsPerToken <= 0 {
+			charsPerToken = 4
+		}
+		return &charRatioTokenizer{charsPerToken: charsPerToken}, nil
+	case TokenizerBPE:
+		if conf.VocabFile == "" {
+			return nil, fmt.Errorf("bpe 分词器需要指定 vocab_file")
+		}
+		return loadBPETokenizer(conf.VocabFile)
+	default:
+		return nil, fmt.Errorf("不支持的分词器: %s（可选 char_ratio、bpe）", conf.Type)
+	}
+}
+
+// charRatioTokenizer 按字符数估算：ASCII 字符每 charsPerToken 个计 1 个 token，其他字符（如中文）每个计 1 个 token
+type charRatioTokenizer struct {
+	charsPerToken float64
+}
+
+func (t *charRatioTokenizer) CountTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return int(math.Ceil(float64(ascii)/t.charsPerToken)) + other
+}
+
+// bpeTokenizer 基于本地 tiktoken 格式词表（每行 "<base64 token> <rank>"）的字节级 BPE
+// 预切分按字母、数字、空白、标点分段近似 tiktoken 的正则，结果为估算值
+type bpeTokenizer struct {
+	ranks map[string]int
+
+	mu    sync.Mutex // 多个任务并行估算时保护 cache
+	cache map[string]int
+}
+
+const (
+	bpeCacheSize     = 100000 // 分段结果缓存的最大条数
+	bpeMaxPieceRunes = 32     // 单个分段的最大字符数
+)
+
+func loadBPETokenizer(path string) (*bpeTokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开词表文件失败: %v", err)
+	}
+	defer file.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("词表第%d行格式错误", lineNumber)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("词表第%d行解码失败: %v", lineNumber, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("词表第%d行 rank 错误: %v", lineNumber, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取词表文件失败: %v", err)
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("词表文件为空: %s", path)
+	}
+	return &bpeTokenizer{ranks: ranks, cache: make(map[string]int)}, nil
+}
+
+func (t *bpeTokenizer) CountTokens(text string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for _, piece := range splitPieces(text) {
+		if n, ok := t.cache[piece]; ok {
+			count += n
+			continue
+		}
+		n := t.countPiece(piece)
+		if len(t.cache) < bpeCacheSize {
+			t.cache[piece] = n
+		}
+		count += n
+	}
+	return count
+}
+
+// countPiece 对一个分段做 BPE 合并：每次合并 rank 最小的相邻两段，直到无法合并
+func (t *bpeTokenizer) countPiece(piece string) int {
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+	parts := make([]string, len(piece))
+	for i := 0; i < len(piece); i++ {
+		parts[i] = piece[i : i+1]
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return len(parts)
+}
+
+// splitPieces 将文本切分为字母、数字、空白、其他字符的连续片段，单词前的一个空格并入单词
+func splitPieces(text string) []string {
+	var pieces []string
+	runes := []rune(text)
+	start := 0
+	for start < len(runes) {
+		end := start
+		if runes[end] == ' ' && end+1 < len(runes) && unicode.IsLetter(runes[end+1]) {
+			end++
+		}
+		class := runeClass(runes[end])
+		// 数字最多3位一段；其他片段限制长度，避免长段中文的 BPE 合并耗时过长
+		maxRunes := bpeMaxPieceRunes
+		if class == runeClassDigit {
+			maxRunes = 3
+		}
+		end++
+		for end < len(runes) && runeClass(runes[end]) == class && end-start < maxRunes {
+			end++
+		}
+		pieces = append(pieces, string(runes[start:end]))
+		start = end
+	}
+	return pieces
+}
+
+const (
+	runeClassLetter = iota
+	runeClassDigit
+	runeClassSpace
+	runeClassOther
+)
+
+func runeClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r):
+		return runeClassLetter
+	case unicode.IsDigit(r):
+		return runeClassDigit
+	case unicode.IsSpace(r):
+		return runeClassSpace
+	default:
+		return runeClassOther
+	}
+}